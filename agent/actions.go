@@ -0,0 +1,37 @@
+package agent
+
+import "fmt"
+
+// actionSet is the discrete actions of an agent from SCUP_AGENT_ACTION with
+// the index of each action by encodeFloat64Slice.
+type actionSet struct {
+	actionSize     int
+	actions        [][]float64
+	actionsIndices map[string]int
+}
+
+func (as *actionSet) loadActionEnv() error {
+	actions, err := parseActions()
+	if err != nil {
+		return fmt.Errorf("cannot load actions: %w", err)
+	}
+
+	actionsIndices := map[string]int{}
+	for i, a := range actions {
+		aEnc := encodeFloat64Slice(a)
+		if _, ok := actionsIndices[aEnc]; ok {
+			return fmt.Errorf("duplicate action: %v", a)
+		}
+		actionsIndices[aEnc] = i
+	}
+
+	as.actionSize = len(actions)
+	as.actions = actions
+	as.actionsIndices = actionsIndices
+
+	return nil
+}
+
+func (as *actionSet) Actions() [][]float64 {
+	return as.actions
+}
@@ -4,9 +4,11 @@ import (
 	"fmt"
 	"os"
 
-	_ "github.com/high-moctane/lab_scup2020/logger"
+	"github.com/high-moctane/lab_scup2020/logger"
 )
 
+var lg = logger.Component("agent")
+
 type Agent interface {
 	Init() error
 	Reset()
@@ -16,6 +18,35 @@ type Agent interface {
 	Load(string) error
 }
 
+// Evaluator is an Agent whose exploration can be switched off. While in
+// evaluation it acts greedily and does not count episodes.
+type Evaluator interface {
+	SetEval(eval bool)
+}
+
+// EpsilonReporter is an Agent which explores epsilon-greedily. ok is false
+// if the current policy is not epsilon-greedy.
+type EpsilonReporter interface {
+	Epsilon() (eps float64, ok bool)
+}
+
+// EpisodeCounter is an Agent which counts its learning episodes.
+type EpisodeCounter interface {
+	EpisodeCount() int
+}
+
+// DiscreteAgent is an Agent which acts from the fixed actions of
+// SCUP_AGENT_ACTION.
+type DiscreteAgent interface {
+	Actions() [][]float64
+}
+
+// ContinuousAgent is an Agent whose actions are in [-ActionMax, ActionMax] in
+// each dimension.
+type ContinuousAgent interface {
+	ActionMax() []float64
+}
+
 func SelectAgent() (Agent, error) {
 	agentName, ok := os.LookupEnv("SCUP_AGENT_NAME")
 	if !ok {
@@ -26,11 +57,29 @@ func SelectAgent() (Agent, error) {
 	switch agentName {
 	case "Q-Learning":
 		res = new(QLearning)
+	case "Double-Q-Learning":
+		res = new(DoubleQLearning)
+	case "SARSA":
+		res = new(SARSA)
+	case "Expected-SARSA":
+		res = new(ExpectedSARSA)
+	case "Q-Lambda":
+		res = new(QLambda)
+	case "SARSA-Lambda":
+		res = new(SARSALambda)
+	case "Tile-Coding":
+		res = new(TileCoding)
+	case "DQN":
+		res = new(DQN)
+	case "DDPG":
+		res = new(DDPG)
+	case "TD3":
+		res = &DDPG{td3: true}
 	default:
 		return nil, fmt.Errorf("invalid agent name")
 	}
 
-	// logger.Get().Info("agent name: %s", agentName)
+	lg.Info("agent name: %s", agentName)
 
 	return res, nil
 }
@@ -46,3 +95,19 @@ func NewAgentDataNotFound(src string) *AgentDataNotFound {
 func (e *AgentDataNotFound) Error() string {
 	return fmt.Sprintf("agent data not found: %s", e.src)
 }
+
+// CheckpointMismatch is returned by Load when the agent data was written
+// with a config that does not fit the current one.
+type CheckpointMismatch struct {
+	src, field       string
+	expected, actual interface{}
+}
+
+func NewCheckpointMismatch(src, field string, expected, actual interface{}) *CheckpointMismatch {
+	return &CheckpointMismatch{src, field, expected, actual}
+}
+
+func (e *CheckpointMismatch) Error() string {
+	return fmt.Sprintf("agent data mismatch: %s: %s expected %v, but %v",
+		e.src, e.field, e.expected, e.actual)
+}
@@ -0,0 +1,781 @@
+package agent
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	utils "github.com/high-moctane/lab_scup2020/utils"
+)
+
+func setTestEnv(t *testing.T, env map[string]string) {
+	t.Helper()
+
+	for k, v := range env {
+		prev, ok := os.LookupEnv(k)
+		if err := os.Setenv(k, v); err != nil {
+			t.Fatal(err)
+		}
+		k := k
+		t.Cleanup(func() {
+			if ok {
+				os.Setenv(k, prev)
+			} else {
+				os.Unsetenv(k)
+			}
+		})
+	}
+}
+
+func testRand() *rand.Rand {
+	return rand.New(utils.NewSource(1))
+}
+
+// testAgentEnv is the agent config shared by the tests with overrides
+// applied.
+func testAgentEnv(overrides map[string]string) map[string]string {
+	env := map[string]string{
+		"SCUP_AGENT_INIT_QVALUE":  "0",
+		"SCUP_AGENT_STATE_THRESH": "-1,1",
+		"SCUP_AGENT_STATE_NUMBER": "4",
+		"SCUP_AGENT_ACTION":       "-1:0:1",
+		"SCUP_AGENT_ALPHA":        "0.5",
+		"SCUP_AGENT_GAMMA":        "0.9",
+		"SCUP_AGENT_EPSILON":      "0.1",
+	}
+	for k, v := range overrides {
+		env[k] = v
+	}
+	return env
+}
+
+func TestTabularLearn(t *testing.T) {
+	setTestEnv(t, testAgentEnv(nil))
+
+	tests := []struct {
+		name     string
+		agent    Agent
+		expected float64
+	}{
+		// target = r + gamma * max Q(s2) = 1 + 0.9 * 4
+		{"Q-Learning", new(QLearning), 0.5*1 + 0.5*(1+0.9*4)},
+		// target = r + gamma * Q(s2, a2) = 1 + 0.9 * 2
+		{"SARSA", new(SARSA), 0.5*1 + 0.5*(1+0.9*2)},
+		// target = r + gamma * ((1 - eps) * max + eps * mean)
+		{"Expected-SARSA", new(ExpectedSARSA), 0.5*1 + 0.5*(1+0.9*(0.9*4+0.1*3))},
+	}
+
+	for _, test := range tests {
+		if err := test.agent.Init(); err != nil {
+			t.Fatalf("[%s] init failed: %v", test.name, err)
+		}
+
+		var table [][]float64
+		switch ag := test.agent.(type) {
+		case *QLearning:
+			table = ag.QTable
+		case *SARSA:
+			table = ag.QTable
+		case *ExpectedSARSA:
+			table = ag.QTable
+		}
+
+		s1, s2 := []float64{-1.5}, []float64{1.5}
+		table[0][0] = 1
+		table[3] = []float64{3, 2, 4}
+
+		test.agent.Learn(s1, []float64{-1}, 1, s2, []float64{0})
+
+		if got := table[0][0]; got != test.expected {
+			t.Errorf("[%s] expected %v, but %v", test.name, test.expected, got)
+		}
+	}
+}
+
+func TestEligibilityTraces(t *testing.T) {
+	setTestEnv(t, map[string]string{
+		"SCUP_AGENT_LAMBDA": "0.5",
+	})
+
+	for _, kind := range []string{"replacing", "accumulating"} {
+		setTestEnv(t, map[string]string{"SCUP_AGENT_TRACE": kind})
+
+		et, err := newEligibilityTraces()
+		if err != nil {
+			t.Fatalf("[%s] %v", kind, err)
+		}
+
+		qtable := [][]float64{{0, 0}, {0, 0}}
+
+		et.visit(0, 0, 2)
+		et.decay(1.)
+		et.visit(0, 0, 2)
+		et.visit(1, 1, 2)
+		et.update(qtable, 1.)
+
+		expected := [][]float64{{1, 0}, {0, 1}}
+		if kind == "accumulating" {
+			expected[0][0] = 1.5
+		}
+		for s := range qtable {
+			for a := range qtable[s] {
+				if qtable[s][a] != expected[s][a] {
+					t.Errorf("[%s] expected %v, but %v", kind, expected, qtable)
+				}
+			}
+		}
+
+		et.reset()
+		if len(et.e) != 0 {
+			t.Errorf("[%s] traces remain after reset: %v", kind, et.e)
+		}
+	}
+}
+
+func TestTileCodingFeatures(t *testing.T) {
+	setTestEnv(t, testAgentEnv(map[string]string{
+		"SCUP_AGENT_STATE_THRESH":  "-1,1:0,4",
+		"SCUP_AGENT_TILE_NUMBER":   "4:2",
+		"SCUP_AGENT_TILING_NUMBER": "4",
+		"SCUP_AGENT_TILING_OFFSET": "asymmetric",
+	}))
+
+	tc := new(TileCoding)
+	if err := tc.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(tc.Weights) != 4*5*3 {
+		t.Fatalf("expected %d features, but %d", 4*5*3, len(tc.Weights))
+	}
+
+	for _, s := range [][]float64{{-5, -5}, {-1, 0}, {0, 2}, {0.99, 3.99}, {1, 4}, {5, 5}} {
+		features := tc.features(s)
+		if len(features) != 4 {
+			t.Errorf("%v: expected 4 features, but %v", s, features)
+		}
+		for i, f := range features {
+			if f < i*tc.tileSize || f >= (i+1)*tc.tileSize {
+				t.Errorf("%v: feature %d out of tiling %d", s, f, i)
+			}
+		}
+	}
+
+	s1, s2 := []float64{0, 2}, []float64{0.5, 1}
+	before := tc.values(tc.features(s1))[2]
+	tc.Learn(s1, []float64{1}, 1, s2, []float64{1})
+	after := tc.values(tc.features(s1))[2]
+	// delta = 1 + 0.9 * 0 - 0, every active weight moves by alpha / tilings * delta.
+	if expected := 0.5; after-before != expected {
+		t.Errorf("expected q change %v, but %v", expected, after-before)
+	}
+}
+
+func TestMLPBackward(t *testing.T) {
+	m := newMLP([]int{3, 5, 4, 2}, testRand())
+	x := []float64{0.3, -0.7, 0.5}
+	gradOut := []float64{1, -2}
+
+	loss := func() float64 {
+		y := m.predict(x)
+		return gradOut[0]*y[0] + gradOut[1]*y[1]
+	}
+
+	grad := m.newGrad()
+	gradIn := m.backward(m.forward(x), gradOut, grad)
+
+	const h = 1e-6
+	const tol = 1e-4
+
+	for l := range m.W {
+		for i := range m.W[l] {
+			orig := m.W[l][i]
+			m.W[l][i] = orig + h
+			lp := loss()
+			m.W[l][i] = orig - h
+			lm := loss()
+			m.W[l][i] = orig
+
+			if d := (lp - lm) / (2 * h); math.Abs(d-grad.W[l][i]) > tol {
+				t.Errorf("W[%d][%d]: numerical %v, but %v", l, i, d, grad.W[l][i])
+			}
+		}
+	}
+
+	for i := range x {
+		orig := x[i]
+		x[i] = orig + h
+		lp := loss()
+		x[i] = orig - h
+		lm := loss()
+		x[i] = orig
+
+		if d := (lp - lm) / (2 * h); math.Abs(d-gradIn[i]) > tol {
+			t.Errorf("x[%d]: numerical %v, but %v", i, d, gradIn[i])
+		}
+	}
+}
+
+func TestDQNSaveLoad(t *testing.T) {
+	setTestEnv(t, testAgentEnv(map[string]string{
+		"SCUP_AGENT_HIDDEN":            "8",
+		"SCUP_AGENT_DQN_LEARNING_RATE": "0.01",
+		"SCUP_AGENT_DQN_BATCH_SIZE":    "4",
+		"SCUP_AGENT_DQN_REPLAY_SIZE":   "16",
+		"SCUP_AGENT_DQN_TRAIN_START":   "4",
+		"SCUP_AGENT_DQN_TARGET_SYNC":   "2",
+	}))
+
+	dqn := new(DQN)
+	if err := dqn.Init(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		dqn.Learn([]float64{0.1}, []float64{1}, 1, []float64{0.2}, []float64{0})
+	}
+
+	path := filepath.Join(t.TempDir(), "dqn.gob")
+	if err := dqn.Save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := new(DQN)
+	if err := loaded.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if err := loaded.Load(path); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(dqn.Online.W, loaded.Online.W) || loaded.Steps != 10 {
+		t.Errorf("loaded dqn differs from saved one")
+	}
+}
+
+func TestDDPGActionRange(t *testing.T) {
+	setTestEnv(t, testAgentEnv(map[string]string{
+		"SCUP_AGENT_HIDDEN":                    "8",
+		"SCUP_AGENT_ACTION_MAX":                "0.35",
+		"SCUP_AGENT_NOISE":                     "ou",
+		"SCUP_AGENT_NOISE_SIGMA":               "1",
+		"SCUP_AGENT_NOISE_THETA":               "0.15",
+		"SCUP_AGENT_DDPG_TAU":                  "0.01",
+		"SCUP_AGENT_DDPG_ACTOR_LEARNING_RATE":  "0.001",
+		"SCUP_AGENT_DDPG_CRITIC_LEARNING_RATE": "0.001",
+		"SCUP_AGENT_DDPG_BATCH_SIZE":           "4",
+		"SCUP_AGENT_DDPG_REPLAY_SIZE":          "16",
+		"SCUP_AGENT_DDPG_TRAIN_START":          "4",
+		"SCUP_AGENT_DDPG_POLICY_DELAY":         "2",
+		"SCUP_AGENT_DDPG_TARGET_NOISE":         "0.2",
+		"SCUP_AGENT_DDPG_TARGET_NOISE_CLIP":    "0.5",
+	}))
+
+	dd := &DDPG{td3: true}
+	if err := dd.Init(); err != nil {
+		t.Fatal(err)
+	}
+	dd.Reset()
+
+	s1 := []float64{0.1}
+	a1 := dd.Action(s1)
+	for i := 0; i < 100; i++ {
+		s2 := []float64{math.Sin(float64(i))}
+		a2 := dd.Action(s2)
+		if len(a2) != 1 || math.Abs(a2[0]) > 0.35 {
+			t.Fatalf("action out of range: %v", a2)
+		}
+		dd.Learn(s1, a1, -math.Abs(s2[0]), s2, a2)
+		s1, a1 = s2, a2
+	}
+}
+
+func TestDDPGLearn(t *testing.T) {
+	setTestEnv(t, testAgentEnv(map[string]string{
+		"SCUP_AGENT_GAMMA":                     "0",
+		"SCUP_AGENT_HIDDEN":                    "16",
+		"SCUP_AGENT_ACTION_MAX":                "0.5",
+		"SCUP_AGENT_NOISE":                     "gaussian",
+		"SCUP_AGENT_NOISE_SIGMA":               "0.3",
+		"SCUP_AGENT_DDPG_TAU":                  "0.05",
+		"SCUP_AGENT_DDPG_ACTOR_LEARNING_RATE":  "0.001",
+		"SCUP_AGENT_DDPG_CRITIC_LEARNING_RATE": "0.01",
+		"SCUP_AGENT_DDPG_BATCH_SIZE":           "16",
+		"SCUP_AGENT_DDPG_REPLAY_SIZE":          "1000",
+		"SCUP_AGENT_DDPG_TRAIN_START":          "16",
+	}))
+
+	// A one step task whose best action is s / 4.
+	best := func(s float64) float64 { return s / 4 }
+
+	dd := new(DDPG)
+	dd.Seed(1)
+	if err := dd.Init(); err != nil {
+		t.Fatal(err)
+	}
+	dd.Reset()
+
+	rng := testRand()
+	for i := 0; i < 20000; i++ {
+		s := []float64{2*rng.Float64() - 1}
+		a := dd.Action(s)
+		r := -math.Pow(a[0]-best(s[0]), 2)
+		dd.Learn(s, a, r, s, a)
+	}
+
+	dd.SetEval(true)
+	for _, s := range []float64{-0.8, 0, 0.8} {
+		if a := dd.Action([]float64{s}); math.Abs(a[0]-best(s)) > 0.06 {
+			t.Errorf("expected action about %v at %v, but %v", best(s), s, a[0])
+		}
+	}
+}
+
+func TestExplorer(t *testing.T) {
+	setTestEnv(t, map[string]string{
+		"SCUP_AGENT_EPSILON":                "0.5",
+		"SCUP_AGENT_EPSILON_MIN":            "0.1",
+		"SCUP_AGENT_EPSILON_DECAY":          "linear",
+		"SCUP_AGENT_EPSILON_DECAY_RATE":     "100",
+		"SCUP_AGENT_TEMPERATURE":            "1",
+		"SCUP_AGENT_TEMPERATURE_MIN":        "0.01",
+		"SCUP_AGENT_TEMPERATURE_DECAY":      "exponential",
+		"SCUP_AGENT_TEMPERATURE_DECAY_RATE": "0.5",
+		"SCUP_AGENT_UCB_C":                  "1",
+	})
+
+	values := []float64{1, 3, 2}
+
+	for _, name := range []string{"EpsilonGreedy", "Boltzmann", "UCB", "Greedy"} {
+		setTestEnv(t, map[string]string{"SCUP_AGENT_EXPLORATION": name})
+
+		ex, err := newExplorer(1, len(values), testRand())
+		if err != nil {
+			t.Fatalf("[%s] %v", name, err)
+		}
+
+		for _, episode := range []int{0, 50, 1000} {
+			sum := 0.
+			for _, p := range ex.probs(values, 0, episode) {
+				sum += p
+			}
+			if math.Abs(sum-1) > 1e-9 {
+				t.Errorf("[%s] probs sum %v at episode %d", name, sum, episode)
+			}
+		}
+
+		if name == "Greedy" && ex.choose(values, 0, 0) != 1 {
+			t.Errorf("[%s] not greedy", name)
+		}
+	}
+
+	eg, err := newEpsilonGreedy(testRand())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for episode, expected := range map[int]float64{0: 0.5, 50: 0.3, 100: 0.1, 1000: 0.1} {
+		if got := eg.eps.value(episode); math.Abs(got-expected) > 1e-9 {
+			t.Errorf("epsilon at episode %d: expected %v, but %v", episode, expected, got)
+		}
+	}
+
+	u, err := newUCB(1, len(values))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range values {
+		if a := u.choose(values, 0, 0); a != i {
+			t.Errorf("ucb: expected untried action %d, but %d", i, a)
+		}
+	}
+}
+
+func TestDoubleQLearningSaveLoad(t *testing.T) {
+	setTestEnv(t, testAgentEnv(nil))
+
+	dq := new(DoubleQLearning)
+	if err := dq.Init(); err != nil {
+		t.Fatal(err)
+	}
+	dq.Reset()
+
+	before := dq.QTable[0][2] + dq.QTable2[0][2]
+	for i := 0; i < 20; i++ {
+		dq.Learn([]float64{-1.5}, []float64{1}, 1, []float64{1.5}, []float64{0})
+	}
+	if after := dq.QTable[0][2] + dq.QTable2[0][2]; after <= before {
+		t.Fatalf("tables did not learn: %v -> %v", before, after)
+	}
+
+	path := filepath.Join(t.TempDir(), "double.gob")
+	if err := dq.Save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := new(DoubleQLearning)
+	if err := loaded.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if err := loaded.Load(path); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(dq.QTable, loaded.QTable) ||
+		!reflect.DeepEqual(dq.QTable2, loaded.QTable2) ||
+		loaded.Episodes != 1 {
+		t.Errorf("loaded double qlearning differs from saved one")
+	}
+}
+
+func TestDiscretizer(t *testing.T) {
+	setTestEnv(t, map[string]string{
+		"SCUP_AGENT_STATE_THRESH": "-1,1:-1,1:1,100:-1,1:-1,1:-3.14,3.14",
+		"SCUP_AGENT_STATE_NUMBER": "4:4:4:6:6:4",
+		"SCUP_AGENT_STATE_BINS":   "uniform:edges=-0.5,0,0.5:log:symmetric:symlog=100:angle",
+	})
+
+	d, err := newDiscretizer()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		dim      int
+		input    float64
+		expected int
+	}{
+		{0, -2, 0}, {0, -0.5, 1}, {0, 0.5, 2}, {0, 1, 3},
+		{1, -0.6, 0}, {1, -0.5, 1}, {1, 0.2, 2}, {1, 0.7, 3},
+		{2, 0.5, 0}, {2, 5, 1}, {2, 50, 2}, {2, 200, 3},
+		{3, -2, 0}, {3, -0.7, 1}, {3, -0.2, 2}, {3, 0.2, 3}, {3, 0.7, 4}, {3, 2, 5},
+		// symlog=100 with half = 2: edges at 0, +-0.0990, +-1
+		{4, -0.5, 1}, {4, -0.05, 2}, {4, 0.05, 3}, {4, 0.5, 4},
+		{5, 0, 0}, {5, 0.7, 0}, {5, -0.7, 0}, {5, 1.6, 1}, {5, 3.1, 2}, {5, -3.1, 2}, {5, -1.6, 3},
+	}
+
+	for i, test := range tests {
+		if got := d.bins[test.dim].index(test.input); got != test.expected {
+			t.Errorf("[%d] dim %d, %v: expected %d, but %d",
+				i, test.dim, test.input, test.expected, got)
+		}
+	}
+
+	if d.size() != 4*4*4*6*6*4 {
+		t.Errorf("invalid size %d", d.size())
+	}
+}
+
+func TestCheckpointMismatch(t *testing.T) {
+	setTestEnv(t, testAgentEnv(nil))
+
+	path := filepath.Join(t.TempDir(), "agent.gob")
+
+	ql := new(QLearning)
+	if err := ql.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if err := ql.Save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	mismatch := new(CheckpointMismatch)
+	notFound := new(AgentDataNotFound)
+
+	if err := ql.Load(path + ".none"); !errors.As(err, &notFound) {
+		t.Errorf("expected AgentDataNotFound, but %v", err)
+	}
+
+	if err := ql.Load(path); err != nil {
+		t.Errorf("load failed: %v", err)
+	}
+
+	sa := new(SARSA)
+	if err := sa.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if err := sa.Load(path); !errors.As(err, &mismatch) {
+		t.Errorf("expected CheckpointMismatch for agent, but %v", err)
+	}
+
+	setTestEnv(t, map[string]string{"SCUP_AGENT_ACTION": "-1:1"})
+	changed := new(QLearning)
+	if err := changed.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if err := changed.Load(path); !errors.As(err, &mismatch) {
+		t.Errorf("expected CheckpointMismatch for actions, but %v", err)
+	}
+
+}
+
+func TestCheckpointLegacy(t *testing.T) {
+	setTestEnv(t, testAgentEnv(nil))
+
+	ql := new(QLearning)
+	if err := ql.Init(); err != nil {
+		t.Fatal(err)
+	}
+	ql.QTable[2][1] = 42
+	ql.Episodes = 7
+
+	// The baseline QLearning.Save gob-encoded the agent itself.
+	legacy := filepath.Join(t.TempDir(), "legacy.gob")
+	f, err := os.Create(legacy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := gob.NewEncoder(f).Encode(ql); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	loaded := new(QLearning)
+	if err := loaded.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if err := loaded.Load(legacy); err != nil {
+		t.Fatalf("load legacy failed: %v", err)
+	}
+	if !reflect.DeepEqual(loaded.QTable, ql.QTable) || loaded.Episodes != 7 {
+		t.Errorf("expected the legacy table and 7 episodes, but %v and %d", loaded.QTable, loaded.Episodes)
+	}
+
+	mismatch := new(CheckpointMismatch)
+
+	setTestEnv(t, map[string]string{"SCUP_AGENT_STATE_NUMBER": "5"})
+	states := new(QLearning)
+	if err := states.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if err := states.Load(legacy); !errors.As(err, &mismatch) {
+		t.Errorf("expected CheckpointMismatch for state number, but %v", err)
+	}
+
+	setTestEnv(t, map[string]string{"SCUP_AGENT_STATE_NUMBER": "4", "SCUP_AGENT_ACTION": "-1:1"})
+	actions := new(QLearning)
+	if err := actions.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if err := actions.Load(legacy); !errors.As(err, &mismatch) {
+		t.Errorf("expected CheckpointMismatch for actions, but %v", err)
+	}
+}
+
+func TestCheckpointRotation(t *testing.T) {
+	setTestEnv(t, testAgentEnv(map[string]string{
+		"SCUP_AGENT_SAVE_KEEP": "2",
+	}))
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "agent.gob")
+
+	ql := new(QLearning)
+	if err := ql.Init(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 4; i++ {
+		ql.Reset()
+		if err := ql.Save(path); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	paths, err := numberedCheckpoints(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		filepath.Join(dir, "agent.ep000003.gob"),
+		filepath.Join(dir, "agent.ep000004.gob"),
+	}
+	if !reflect.DeepEqual(expected, paths) {
+		t.Errorf("expected %v, but %v", expected, paths)
+	}
+
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) != 3 {
+		t.Errorf("expected 3 files, but %d", len(infos))
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)-2] ^= 0xff
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ql.Load(path); err == nil {
+		t.Errorf("expected checksum error for corrupted data")
+	}
+	if err := ql.Load(paths[1]); err != nil {
+		t.Errorf("load failed: %v", err)
+	}
+	if ql.Episodes != 4 {
+		t.Errorf("expected episodes 4, but %d", ql.Episodes)
+	}
+
+	// An unnumbered save leaves the numbered copies and no temporary files.
+	best := filepath.Join(dir, "agent.best.gob")
+	ql.Reset()
+	if err := SaveUnnumbered(ql, best); err != nil {
+		t.Fatal(err)
+	}
+	if after, err := numberedCheckpoints(path); err != nil || !reflect.DeepEqual(expected, after) {
+		t.Errorf("expected %v, but %v (%v)", expected, after, err)
+	}
+	if infos, err := ioutil.ReadDir(dir); err != nil || len(infos) != 4 {
+		t.Errorf("expected 4 files, but %v (%v)", infos, err)
+	}
+	if err := ql.Load(best); err != nil {
+		t.Errorf("load failed: %v", err)
+	}
+}
+
+func TestTableExportImport(t *testing.T) {
+	setTestEnv(t, testAgentEnv(map[string]string{
+		"SCUP_AGENT_STATE_THRESH": "-1,1:-2,2",
+		"SCUP_AGENT_STATE_NUMBER": "4:3",
+	}))
+
+	for _, format := range []string{TableCSV, TableJSON, TableNPY} {
+		src := new(QLearning)
+		src.Seed(1)
+		if err := src.Init(); err != nil {
+			t.Fatal(err)
+		}
+		for s := range src.QTable {
+			for a := range src.QTable[s] {
+				src.QTable[s][a] = float64(s*10+a) + 0.25
+			}
+		}
+
+		buf := new(bytes.Buffer)
+		if err := ExportTable(buf, src, format); err != nil {
+			t.Fatalf("[%s] export failed: %v", format, err)
+		}
+
+		dst := new(DoubleQLearning)
+		dst.Seed(2)
+		if err := dst.Init(); err != nil {
+			t.Fatal(err)
+		}
+		if err := ImportTable(buf, dst, format); err != nil {
+			t.Fatalf("[%s] import failed: %v", format, err)
+		}
+		if !reflect.DeepEqual(dst.Table(), src.QTable) {
+			t.Errorf("[%s] expected %v, but %v", format, src.QTable, dst.Table())
+		}
+	}
+}
+
+func TestTableImportLayoutMismatch(t *testing.T) {
+	base := map[string]string{
+		"SCUP_AGENT_STATE_THRESH": "-1,1:-2,2",
+		"SCUP_AGENT_STATE_NUMBER": "4:3",
+	}
+
+	for _, test := range []struct {
+		name      string
+		overrides map[string]string
+		formats   []string
+	}{
+		{"actions", map[string]string{"SCUP_AGENT_ACTION": "-2:0:2"}, []string{TableCSV, TableJSON}},
+		{"state number", map[string]string{"SCUP_AGENT_STATE_NUMBER": "3:4"}, []string{TableCSV, TableJSON}},
+		{"state thresh", map[string]string{"SCUP_AGENT_STATE_THRESH": "-1,1:-3,3"}, []string{TableJSON}},
+	} {
+		for _, format := range test.formats {
+			setTestEnv(t, testAgentEnv(base))
+			src := new(QLearning)
+			if err := src.Init(); err != nil {
+				t.Fatal(err)
+			}
+			buf := new(bytes.Buffer)
+			if err := ExportTable(buf, src, format); err != nil {
+				t.Fatal(err)
+			}
+
+			setTestEnv(t, test.overrides)
+			dst := new(QLearning)
+			if err := dst.Init(); err != nil {
+				t.Fatal(err)
+			}
+			if err := ImportTable(buf, dst, format); err == nil {
+				t.Errorf("[%s %s] expected a mismatch error", test.name, format)
+			}
+		}
+	}
+}
+
+func TestTableImportShapeMismatch(t *testing.T) {
+	setTestEnv(t, testAgentEnv(nil))
+
+	ql := new(QLearning)
+	if err := ql.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := new(bytes.Buffer)
+	if err := exportNPY(buf, [][]float64{{1, 2}, {3, 4}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ImportTable(buf, ql, TableNPY); err == nil {
+		t.Error("expected shape mismatch error")
+	}
+}
+
+func TestTableImportNPYBounds(t *testing.T) {
+	setTestEnv(t, testAgentEnv(nil))
+
+	ql := new(QLearning)
+	if err := ql.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := new(bytes.Buffer)
+	if err := exportNPY(buf, ql.Table()); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	headerEnd := bytes.IndexByte(data, '\n') + 1
+	header := string(data[10:headerEnd])
+	shape := fmt.Sprintf("(%d, %d)", len(ql.Table()), len(ql.Actions()))
+
+	withShape := func(s string) []byte {
+		h := strings.Replace(header, shape, s, 1)
+		res := bytes.NewBufferString(npyMagic)
+		res.Write([]byte{1, 0})
+		binary.Write(res, binary.LittleEndian, uint16(len(h)))
+		res.WriteString(h)
+		res.Write(data[headerEnd:])
+		return res.Bytes()
+	}
+
+	for i, stream := range [][]byte{
+		// A shape far over the table must fail before it is allocated.
+		withShape(fmt.Sprintf("(4611686018427387903, %d)", len(ql.Actions()))),
+		withShape(fmt.Sprintf("(%d, 4611686018427387903)", len(ql.Table()))),
+		// The shape fits, but the data is cut off.
+		data[:len(data)-8],
+	} {
+		if err := ImportTable(bytes.NewReader(stream), ql, TableNPY); err == nil {
+			t.Errorf("[%d] expected an error", i)
+		}
+	}
+
+	if err := ImportTable(bytes.NewReader(withShape(shape)), ql, TableNPY); err != nil {
+		t.Errorf("import failed: %v", err)
+	}
+}
@@ -0,0 +1,327 @@
+package agent
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	utils "github.com/high-moctane/lab_scup2020/utils"
+)
+
+const checkpointMagic = "SCUP-AGENT"
+
+// Version 1 stores the agent data right after the header. Version 2 stores
+// it as a byte slice whose SHA-256 is in the header.
+const checkpointVersion = 2
+
+// checkpointHeader describes the agent which wrote a checkpoint. It precedes
+// the agent data in the file so that Load can check the data fits the
+// current config before decoding it.
+type checkpointHeader struct {
+	Magic   string
+	Version int
+	Agent   string
+
+	StateThresh [][]float64
+	StateNumber []int
+	StateBins   []string
+	Actions     [][]float64
+
+	// Shape holds agent specific structure such as hidden layer sizes.
+	// It must match on Load.
+	Shape map[string]string
+
+	// Params holds hyperparameters. They may change between runs.
+	Params map[string]string
+
+	Episodes int
+
+	Checksum []byte
+}
+
+func newCheckpointHeader(agent string) *checkpointHeader {
+	return &checkpointHeader{
+		Magic:   checkpointMagic,
+		Version: checkpointVersion,
+		Agent:   agent,
+		Shape:   map[string]string{},
+		Params:  map[string]string{},
+	}
+}
+
+// saveCheckpoint writes h followed by data to dst. The file is written to a
+// temporary file, synced and renamed so that dst always holds a complete
+// checkpoint. If SCUP_AGENT_SAVE_KEEP is positive, a copy numbered by
+// h.Episodes is kept too and only the last SCUP_AGENT_SAVE_KEEP copies remain.
+func saveCheckpoint(dst string, h *checkpointHeader, data interface{}) error {
+	keep, err := utils.GetEnvIntDefault("SCUP_AGENT_SAVE_KEEP", 0)
+	if err != nil {
+		return fmt.Errorf("cannot save %s to %s: %w", h.Agent, dst, err)
+	}
+
+	body := bytes.NewBuffer(nil)
+	if err := gob.NewEncoder(body).Encode(data); err != nil {
+		return fmt.Errorf("cannot save %s to %s: %w", h.Agent, dst, err)
+	}
+	sum := sha256.Sum256(body.Bytes())
+	h.Checksum = sum[:]
+
+	buf := bytes.NewBuffer(nil)
+	enc := gob.NewEncoder(buf)
+	if err := enc.Encode(h); err != nil {
+		return fmt.Errorf("cannot save %s to %s: %w", h.Agent, dst, err)
+	}
+	if err := enc.Encode(body.Bytes()); err != nil {
+		return fmt.Errorf("cannot save %s to %s: %w", h.Agent, dst, err)
+	}
+
+	if err := utils.WriteFileAtomic(dst, buf.Bytes()); err != nil {
+		return fmt.Errorf("cannot save %s to %s: %w", h.Agent, dst, err)
+	}
+
+	lg.Info("agent saved to %s", dst)
+
+	if keep > 0 {
+		if err := utils.WriteFileAtomic(numberedCheckpointPath(dst, h.Episodes), buf.Bytes()); err != nil {
+			return fmt.Errorf("cannot save %s to %s: %w", h.Agent, dst, err)
+		}
+		if err := pruneCheckpoints(dst, keep); err != nil {
+			return fmt.Errorf("cannot save %s to %s: %w", h.Agent, dst, err)
+		}
+	}
+
+	return nil
+}
+
+// SaveUnnumbered saves ag to dst without the numbered copies of
+// SCUP_AGENT_SAVE_KEEP, e.g. for a best agent which is not a point in the
+// history of the run. ag is saved in a temporary directory next to dst and
+// only the checkpoint itself is renamed to dst.
+func SaveUnnumbered(ag Agent, dst string) error {
+	dir := filepath.Dir(dst)
+
+	tmp, err := ioutil.TempDir(dir, filepath.Base(dst)+".tmp")
+	if err != nil {
+		return fmt.Errorf("cannot save agent to %s: %w", dst, err)
+	}
+	defer os.RemoveAll(tmp)
+
+	if err := ag.Save(filepath.Join(tmp, filepath.Base(dst))); err != nil {
+		return err
+	}
+	if err := os.Rename(filepath.Join(tmp, filepath.Base(dst)), dst); err != nil {
+		return fmt.Errorf("cannot save agent to %s: %w", dst, err)
+	}
+
+	// Make the rename durable.
+	d, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("cannot save agent to %s: %w", dst, err)
+	}
+	defer d.Close()
+	if err := d.Sync(); err != nil {
+		return fmt.Errorf("cannot save agent to %s: %w", dst, err)
+	}
+
+	return nil
+}
+
+// numberedCheckpointPath returns e.g. agent_up.ep000120.gob for agent_up.gob.
+func numberedCheckpointPath(path string, episode int) string {
+	ext := filepath.Ext(path)
+	return fmt.Sprintf("%s.ep%06d%s", strings.TrimSuffix(path, ext), episode, ext)
+}
+
+// numberedCheckpoints returns the numbered checkpoints of path in ascending
+// order of episode.
+func numberedCheckpoints(path string) ([]string, error) {
+	ext := filepath.Ext(path)
+	base := strings.TrimSuffix(filepath.Base(path), ext)
+	re := regexp.MustCompile("^" + regexp.QuoteMeta(base) + `\.ep(\d+)` + regexp.QuoteMeta(ext) + "$")
+
+	infos, err := ioutil.ReadDir(filepath.Dir(path))
+	if err != nil {
+		return nil, err
+	}
+
+	type numbered struct {
+		path    string
+		episode int
+	}
+	found := []numbered{}
+	for _, info := range infos {
+		m := re.FindStringSubmatch(info.Name())
+		if m == nil {
+			continue
+		}
+		episode, err := strconv.Atoi(m[1])
+		if err != nil {
+			continue
+		}
+		found = append(found, numbered{filepath.Join(filepath.Dir(path), info.Name()), episode})
+	}
+
+	sort.Slice(found, func(i, j int) bool { return found[i].episode < found[j].episode })
+
+	res := make([]string, len(found))
+	for i, n := range found {
+		res[i] = n.path
+	}
+	return res, nil
+}
+
+func pruneCheckpoints(path string, keep int) error {
+	paths, err := numberedCheckpoints(path)
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < len(paths)-keep; i++ {
+		if err := os.Remove(paths[i]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// loadCheckpoint reads the checkpoint at src into data after validating its
+// header against expected. It returns *AgentDataNotFound if src does not
+// exist and *CheckpointMismatch if the checkpoint does not fit.
+func loadCheckpoint(src string, expected *checkpointHeader, data interface{}) error {
+	pathError := new(os.PathError)
+
+	f, err := os.Open(src)
+	if err != nil {
+		if errors.As(err, &pathError) {
+			return NewAgentDataNotFound(src)
+		} else {
+			return fmt.Errorf("cannot load %s from %s: %w", expected.Agent, src, err)
+		}
+	}
+	defer f.Close()
+
+	dec := gob.NewDecoder(bufio.NewReader(f))
+
+	var h checkpointHeader
+	if err := dec.Decode(&h); err != nil {
+		return fmt.Errorf("cannot load %s from %s: %w", expected.Agent, src, err)
+	}
+
+	if err := validateCheckpoint(src, expected, &h); err != nil {
+		return err
+	}
+
+	if h.Version == 1 {
+		if err := dec.Decode(data); err != nil {
+			return fmt.Errorf("cannot load %s from %s: %w", expected.Agent, src, err)
+		}
+	} else {
+		var body []byte
+		if err := dec.Decode(&body); err != nil {
+			return fmt.Errorf("cannot load %s from %s: %w", expected.Agent, src, err)
+		}
+
+		if sum := sha256.Sum256(body); !bytes.Equal(sum[:], h.Checksum) {
+			return fmt.Errorf("cannot load %s from %s: checksum mismatch", expected.Agent, src)
+		}
+
+		if err := gob.NewDecoder(bytes.NewReader(body)).Decode(data); err != nil {
+			return fmt.Errorf("cannot load %s from %s: %w", expected.Agent, src, err)
+		}
+	}
+
+	lg.Info("agent loaded from %s", src)
+
+	return nil
+}
+
+// legacyTable is the data QLearning.Save wrote before checkpoints had a
+// header.
+type legacyTable struct {
+	QTable   [][]float64
+	Episodes int
+}
+
+// loadLegacyTable reads src if it holds unversioned QLearning data. It returns
+// false if src cannot be read or is a versioned checkpoint, whose header has
+// no QTable.
+func loadLegacyTable(src string) (*legacyTable, bool) {
+	f, err := os.Open(src)
+	if err != nil {
+		return nil, false
+	}
+	defer f.Close()
+
+	var data legacyTable
+	if err := gob.NewDecoder(bufio.NewReader(f)).Decode(&data); err != nil || data.QTable == nil {
+		return nil, false
+	}
+	return &data, true
+}
+
+func validateCheckpoint(src string, expected, h *checkpointHeader) error {
+	if h.Magic != checkpointMagic {
+		return NewCheckpointMismatch(src, "format", "versioned checkpoint", "unknown data")
+	}
+	if h.Version > checkpointVersion {
+		return NewCheckpointMismatch(src, "version", expected.Version, h.Version)
+	}
+
+	checks := []struct {
+		field            string
+		expected, actual interface{}
+	}{
+		{"agent", expected.Agent, h.Agent},
+		{"state thresh", expected.StateThresh, h.StateThresh},
+		{"state number", expected.StateNumber, h.StateNumber},
+		{"state bins", expected.StateBins, h.StateBins},
+		{"actions", expected.Actions, h.Actions},
+	}
+	for _, c := range checks {
+		if !reflect.DeepEqual(c.expected, c.actual) {
+			return NewCheckpointMismatch(src, c.field, c.expected, c.actual)
+		}
+	}
+
+	keys := map[string]bool{}
+	for k := range expected.Shape {
+		keys[k] = true
+	}
+	for k := range h.Shape {
+		keys[k] = true
+	}
+	for _, k := range sortedKeys(keys) {
+		if expected.Shape[k] != h.Shape[k] {
+			return NewCheckpointMismatch(src, k, expected.Shape[k], h.Shape[k])
+		}
+	}
+
+	for k, v := range h.Params {
+		if expected.Params[k] != v {
+			lg.Warn("agent %s: %s changed from %s to %s", src, k, v, expected.Params[k])
+		}
+	}
+
+	return nil
+}
+
+func sortedKeys(m map[string]bool) []string {
+	res := make([]string, 0, len(m))
+	for k := range m {
+		res = append(res, k)
+	}
+	sort.Strings(res)
+	return res
+}
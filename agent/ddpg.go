@@ -0,0 +1,449 @@
+package agent
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	utils "github.com/high-moctane/lab_scup2020/utils"
+)
+
+// DDPG is a deterministic actor-critic agent with continuous actions. With
+// td3 set it uses twin critics, target policy smoothing and delayed policy
+// updates (TD3).
+type DDPG struct {
+	randomness
+
+	td3  bool
+	eval bool
+
+	gamma, tau        float64
+	actorLR, criticLR float64
+	policyDelay       int
+	targetNoise       float64
+	targetNoiseClip   float64
+
+	noise      string
+	noiseSigma float64
+	noiseTheta float64
+	noiseState []float64
+
+	stateThresh [][]float64 // [[min, max], [min, max], ...]
+	hidden      []int
+	actionMax   []float64
+
+	batchSize  int
+	trainStart int
+	replay     *replayBuffer
+
+	Actor, ActorTarget     *mlp
+	Critic1, Critic1Target *mlp
+	Critic2, Critic2Target *mlp
+	Steps                  int
+	Episodes               int
+}
+
+func (dd *DDPG) Init() error {
+	dd.InitRand()
+
+	if err := dd.loadEnv(); err != nil {
+		return fmt.Errorf("cannot init ddpg: %w", err)
+	}
+
+	stateDim := len(dd.stateThresh)
+	actionDim := len(dd.actionMax)
+
+	actorSizes := []int{stateDim}
+	actorSizes = append(actorSizes, dd.hidden...)
+	actorSizes = append(actorSizes, actionDim)
+
+	criticSizes := []int{stateDim + actionDim}
+	criticSizes = append(criticSizes, dd.hidden...)
+	criticSizes = append(criticSizes, 1)
+
+	dd.Actor = newMLP(actorSizes, dd.Rand())
+	dd.ActorTarget = dd.Actor.clone()
+	dd.Critic1 = newMLP(criticSizes, dd.Rand())
+	dd.Critic1Target = dd.Critic1.clone()
+	if dd.td3 {
+		dd.Critic2 = newMLP(criticSizes, dd.Rand())
+		dd.Critic2Target = dd.Critic2.clone()
+	}
+
+	dd.noiseState = make([]float64, actionDim)
+
+	dd.Steps = 0
+	dd.Episodes = 0
+
+	return nil
+}
+
+func (dd *DDPG) Reset() {
+	for i := range dd.noiseState {
+		dd.noiseState[i] = 0
+	}
+	if dd.eval {
+		return
+	}
+	dd.Episodes += 1
+	lg.Info("ddpg episode %d", dd.Episodes)
+}
+
+func (dd *DDPG) Action(s []float64) []float64 {
+	a := dd.policy(dd.Actor, s)
+	if dd.eval {
+		return a
+	}
+
+	for i := range a {
+		var n float64
+		switch dd.noise {
+		case "gaussian":
+			n = dd.noiseSigma * dd.Rand().NormFloat64()
+		case "ou":
+			dd.noiseState[i] += -dd.noiseTheta*dd.noiseState[i] + dd.noiseSigma*dd.Rand().NormFloat64()
+			n = dd.noiseState[i]
+		}
+		a[i] = clip(a[i]+n*dd.actionMax[i], dd.actionMax[i])
+	}
+
+	return a
+}
+
+func (dd *DDPG) SetEval(eval bool) {
+	dd.eval = eval
+}
+
+func (dd *DDPG) EpisodeCount() int {
+	return dd.Episodes
+}
+
+func (dd *DDPG) ActionMax() []float64 {
+	return append([]float64(nil), dd.actionMax...)
+}
+
+func (dd *DDPG) Learn(s1, a1 []float64, r float64, s2, a2 []float64) {
+	dd.replay.push(transition{s1, a1, r, s2, a2})
+	dd.Steps++
+
+	if dd.replay.len() < dd.trainStart {
+		return
+	}
+
+	batch := dd.replay.sample(dd.batchSize, dd.Rand())
+
+	// Critic
+	grad1 := dd.Critic1.newGrad()
+	var grad2 *mlpGrad
+	if dd.td3 {
+		grad2 = dd.Critic2.newGrad()
+	}
+
+	for _, t := range batch {
+		aTarget := dd.policy(dd.ActorTarget, t.s2)
+		if dd.td3 {
+			for i := range aTarget {
+				n := clip(dd.targetNoise*dd.Rand().NormFloat64(), dd.targetNoiseClip)
+				aTarget[i] = clip(aTarget[i]+n*dd.actionMax[i], dd.actionMax[i])
+			}
+		}
+
+		x2 := dd.criticInput(t.s2, aTarget)
+		q2 := dd.Critic1Target.predict(x2)[0]
+		if dd.td3 {
+			q2 = math.Min(q2, dd.Critic2Target.predict(x2)[0])
+		}
+		y := t.r + dd.gamma*q2
+
+		x1 := dd.criticInput(t.s1, t.a1)
+		acts := dd.Critic1.forward(x1)
+		dd.Critic1.backward(acts, []float64{acts[len(acts)-1][0] - y}, grad1)
+		if dd.td3 {
+			acts := dd.Critic2.forward(x1)
+			dd.Critic2.backward(acts, []float64{acts[len(acts)-1][0] - y}, grad2)
+		}
+	}
+
+	dd.Critic1.step(grad1, dd.criticLR, dd.batchSize)
+	if dd.td3 {
+		dd.Critic2.step(grad2, dd.criticLR, dd.batchSize)
+	}
+
+	if dd.Steps%dd.policyDelay != 0 {
+		return
+	}
+
+	// Actor: ascend Q1(s, actor(s))
+	actorGrad := dd.Actor.newGrad()
+	stateDim := len(dd.stateThresh)
+
+	for _, t := range batch {
+		actorActs := dd.Actor.forward(normalizeState(t.s1, dd.stateThresh))
+		out := actorActs[len(actorActs)-1]
+
+		a := make([]float64, len(out))
+		for i, z := range out {
+			a[i] = math.Tanh(z) * dd.actionMax[i]
+		}
+
+		criticActs := dd.Critic1.forward(dd.criticInput(t.s1, a))
+		gradIn := dd.Critic1.backward(criticActs, []float64{-1.}, dd.Critic1.newGrad())
+
+		gradOut := make([]float64, len(out))
+		for i, z := range out {
+			// criticInput feeds a / actionMax, so the scales cancel.
+			th := math.Tanh(z)
+			gradOut[i] = gradIn[stateDim+i] * (1. - th*th)
+		}
+
+		dd.Actor.backward(actorActs, gradOut, actorGrad)
+	}
+
+	dd.Actor.step(actorGrad, dd.actorLR, dd.batchSize)
+
+	dd.ActorTarget.softUpdate(dd.Actor, dd.tau)
+	dd.Critic1Target.softUpdate(dd.Critic1, dd.tau)
+	if dd.td3 {
+		dd.Critic2Target.softUpdate(dd.Critic2, dd.tau)
+	}
+}
+
+func (dd *DDPG) Save(dst string) error {
+	return saveCheckpoint(dst, dd.header(), dd)
+}
+
+func (dd *DDPG) Load(src string) error {
+	var data DDPG
+	if err := loadCheckpoint(src, dd.header(), &data); err != nil {
+		return err
+	}
+
+	if !dd.Actor.sameShape(data.Actor) || !dd.Actor.sameShape(data.ActorTarget) ||
+		!dd.Critic1.sameShape(data.Critic1) || !dd.Critic1.sameShape(data.Critic1Target) ||
+		dd.td3 && (!dd.Critic2.sameShape(data.Critic2) || !dd.Critic2.sameShape(data.Critic2Target)) {
+		return NewCheckpointMismatch(src, "network", dd.Actor.Sizes, data.Actor.Sizes)
+	}
+
+	dd.Actor, dd.ActorTarget = data.Actor, data.ActorTarget
+	dd.Critic1, dd.Critic1Target = data.Critic1, data.Critic1Target
+	if dd.td3 {
+		dd.Critic2, dd.Critic2Target = data.Critic2, data.Critic2Target
+	}
+	dd.Steps = data.Steps
+	dd.Episodes = data.Episodes
+
+	return nil
+}
+
+func (dd *DDPG) header() *checkpointHeader {
+	name := "DDPG"
+	if dd.td3 {
+		name = "TD3"
+	}
+
+	h := newCheckpointHeader(name)
+	h.StateThresh = dd.stateThresh
+	h.Shape["hidden"] = fmt.Sprint(dd.hidden)
+	h.Shape["action max"] = fmt.Sprint(dd.actionMax)
+	h.Params["gamma"] = fmt.Sprint(dd.gamma)
+	h.Params["tau"] = fmt.Sprint(dd.tau)
+	h.Params["actor learning rate"] = fmt.Sprint(dd.actorLR)
+	h.Params["critic learning rate"] = fmt.Sprint(dd.criticLR)
+	h.Params["policy delay"] = fmt.Sprint(dd.policyDelay)
+	h.Params["noise"] = dd.noise
+	h.Params["noise sigma"] = fmt.Sprint(dd.noiseSigma)
+	h.Episodes = dd.Episodes
+	return h
+}
+
+// policy returns the deterministic action of actor without noise.
+func (dd *DDPG) policy(actor *mlp, s []float64) []float64 {
+	out := actor.predict(normalizeState(s, dd.stateThresh))
+	res := make([]float64, len(out))
+	for i, z := range out {
+		res[i] = math.Tanh(z) * dd.actionMax[i]
+	}
+	return res
+}
+
+func (dd *DDPG) criticInput(s, a []float64) []float64 {
+	res := normalizeState(s, dd.stateThresh)
+	for i, v := range a {
+		res = append(res, v/dd.actionMax[i])
+	}
+	return res
+}
+
+func clip(v, maxAbs float64) float64 {
+	return math.Max(-maxAbs, math.Min(maxAbs, v))
+}
+
+func (dd *DDPG) loadEnv() error {
+	if err := dd.loadParamsEnv(); err != nil {
+		return fmt.Errorf("cannot load env: %w", err)
+	}
+	if err := dd.loadNoiseEnv(); err != nil {
+		return fmt.Errorf("cannot load env: %w", err)
+	}
+	if err := dd.loadNetworkEnv(); err != nil {
+		return fmt.Errorf("cannot load env: %w", err)
+	}
+	return nil
+}
+
+func (dd *DDPG) loadParamsEnv() error {
+	gamma, err := utils.GetEnvFloat64("SCUP_AGENT_GAMMA")
+	if err != nil {
+		return fmt.Errorf("cannot load params env: %w", err)
+	}
+
+	tau, err := utils.GetEnvFloat64("SCUP_AGENT_DDPG_TAU")
+	if err != nil {
+		return fmt.Errorf("cannot load params env: %w", err)
+	}
+
+	actorLR, err := utils.GetEnvFloat64("SCUP_AGENT_DDPG_ACTOR_LEARNING_RATE")
+	if err != nil {
+		return fmt.Errorf("cannot load params env: %w", err)
+	}
+
+	criticLR, err := utils.GetEnvFloat64("SCUP_AGENT_DDPG_CRITIC_LEARNING_RATE")
+	if err != nil {
+		return fmt.Errorf("cannot load params env: %w", err)
+	}
+
+	batchSize, err := utils.GetEnvInt("SCUP_AGENT_DDPG_BATCH_SIZE")
+	if err != nil {
+		return fmt.Errorf("cannot load params env: %w", err)
+	}
+
+	replaySize, err := utils.GetEnvInt("SCUP_AGENT_DDPG_REPLAY_SIZE")
+	if err != nil {
+		return fmt.Errorf("cannot load params env: %w", err)
+	}
+
+	trainStart, err := utils.GetEnvInt("SCUP_AGENT_DDPG_TRAIN_START")
+	if err != nil {
+		return fmt.Errorf("cannot load params env: %w", err)
+	}
+
+	if batchSize < 1 || replaySize < 1 {
+		return fmt.Errorf("batch size and replay size must be positive")
+	}
+	if trainStart < batchSize {
+		trainStart = batchSize
+	}
+
+	policyDelay := 1
+	if dd.td3 {
+		policyDelay, err = utils.GetEnvInt("SCUP_AGENT_DDPG_POLICY_DELAY")
+		if err != nil {
+			return fmt.Errorf("cannot load params env: %w", err)
+		}
+		if policyDelay < 1 {
+			return fmt.Errorf("invalid policy delay: %d", policyDelay)
+		}
+
+		dd.targetNoise, err = utils.GetEnvFloat64("SCUP_AGENT_DDPG_TARGET_NOISE")
+		if err != nil {
+			return fmt.Errorf("cannot load params env: %w", err)
+		}
+
+		dd.targetNoiseClip, err = utils.GetEnvFloat64("SCUP_AGENT_DDPG_TARGET_NOISE_CLIP")
+		if err != nil {
+			return fmt.Errorf("cannot load params env: %w", err)
+		}
+	}
+
+	dd.gamma = gamma
+	dd.tau = tau
+	dd.actorLR = actorLR
+	dd.criticLR = criticLR
+	dd.policyDelay = policyDelay
+	dd.batchSize = batchSize
+	dd.trainStart = trainStart
+	dd.replay = newReplayBuffer(replaySize)
+
+	return nil
+}
+
+// loadNoiseEnv loads the exploration noise. Sigma is relative to the action
+// range.
+func (dd *DDPG) loadNoiseEnv() error {
+	noise, ok := os.LookupEnv("SCUP_AGENT_NOISE")
+	if !ok {
+		return fmt.Errorf("cannot find SCUP_AGENT_NOISE")
+	}
+
+	sigma, err := utils.GetEnvFloat64("SCUP_AGENT_NOISE_SIGMA")
+	if err != nil {
+		return fmt.Errorf("cannot load noise env: %w", err)
+	}
+
+	switch noise {
+	case "gaussian":
+	case "ou":
+		theta, err := utils.GetEnvFloat64("SCUP_AGENT_NOISE_THETA")
+		if err != nil {
+			return fmt.Errorf("cannot load noise env: %w", err)
+		}
+		dd.noiseTheta = theta
+	default:
+		return fmt.Errorf("invalid noise: %s", noise)
+	}
+
+	dd.noise = noise
+	dd.noiseSigma = sigma
+
+	return nil
+}
+
+func (dd *DDPG) loadNetworkEnv() error {
+	stateThresh, err := parseStateThresh()
+	if err != nil {
+		return fmt.Errorf("cannot load network env: %w", err)
+	}
+
+	hidden, err := parseHiddenLayers()
+	if err != nil {
+		return fmt.Errorf("cannot load network env: %w", err)
+	}
+
+	actionMax, err := parseActionMax()
+	if err != nil {
+		return fmt.Errorf("cannot load network env: %w", err)
+	}
+
+	dd.stateThresh = stateThresh
+	dd.hidden = hidden
+	dd.actionMax = actionMax
+
+	return nil
+}
+
+// parseActionMax parses the max absolute value of each action dimension,
+// e.g. "1" for Cartpole.
+func parseActionMax() ([]float64, error) {
+	str, ok := os.LookupEnv("SCUP_AGENT_ACTION_MAX")
+	if !ok {
+		return nil, fmt.Errorf("cannot find SCUP_AGENT_ACTION_MAX")
+	}
+
+	res := []float64{}
+
+	for _, elem := range strings.Split(str, ":") {
+		v, err := strconv.ParseFloat(elem, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid action max value: %w", err)
+		}
+		if v <= 0 {
+			return nil, fmt.Errorf("invalid action max value: %v", v)
+		}
+
+		res = append(res, v)
+	}
+
+	return res, nil
+}
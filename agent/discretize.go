@@ -0,0 +1,236 @@
+package agent
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	utils "github.com/high-moctane/lab_scup2020/utils"
+)
+
+// Kinds of per-dimension binning for SCUP_AGENT_STATE_BINS.
+const (
+	binsUniform   = "uniform"   // number-2 equal bins in [min, max] plus two overflow bins
+	binsEdges     = "edges"     // explicit edges, e.g. edges=-0.1,0,0.1
+	binsLog       = "log"       // log spaced in [min, max], 0 < min
+	binsSymmetric = "symmetric" // equal bins mirrored around zero
+	binsSymlog    = "symlog"    // log spaced mirrored around zero, e.g. symlog=10
+	binsAngle     = "angle"     // number equal bins wrapping around [-pi, pi), no overflow bins
+)
+
+const defaultSymlogScale = 10.
+
+// discretizer maps a continuous state to an index of a QTable.
+type discretizer struct {
+	thresh [][]float64 // [[min, max], [min, max], ...]
+	number []int
+	specs  []string
+	bins   []*binning
+}
+
+type binning struct {
+	kind   string
+	thresh []float64
+	number int
+	edges  []float64 // inner edges in ascending order
+}
+
+// newDiscretizer makes a discretizer from SCUP_AGENT_STATE_THRESH,
+// SCUP_AGENT_STATE_NUMBER and the optional SCUP_AGENT_STATE_BINS, e.g.
+// "uniform:symlog=20:uniform:angle". Every dimension is uniform by default.
+func newDiscretizer() (*discretizer, error) {
+	thresh, err := parseStateThresh()
+	if err != nil {
+		return nil, fmt.Errorf("cannot make discretizer: %w", err)
+	}
+
+	number, err := parseStateNumber()
+	if err != nil {
+		return nil, fmt.Errorf("cannot make discretizer: %w", err)
+	}
+
+	if len(thresh) != len(number) {
+		return nil, fmt.Errorf("len(stateThresh) == %v, but len(stateNumber) == %v",
+			len(thresh), len(number))
+	}
+
+	specs := make([]string, len(number))
+	for i := range specs {
+		specs[i] = binsUniform
+	}
+	if str := utils.GetEnvStringDefault("SCUP_AGENT_STATE_BINS", ""); str != "" {
+		specs = strings.Split(str, ":")
+		if len(specs) != len(number) {
+			return nil, fmt.Errorf("len(stateBins) == %v, but len(stateNumber) == %v",
+				len(specs), len(number))
+		}
+	}
+
+	bins := make([]*binning, len(number))
+	for i, spec := range specs {
+		b, err := newBinning(spec, thresh[i], number[i])
+		if err != nil {
+			return nil, fmt.Errorf("cannot make discretizer of dim %d: %w", i, err)
+		}
+		bins[i] = b
+	}
+
+	return &discretizer{thresh, number, specs, bins}, nil
+}
+
+func (d *discretizer) size() int {
+	res := 1
+	for _, n := range d.number {
+		res *= n
+	}
+	return res
+}
+
+func (d *discretizer) index(s []float64) int {
+	res := d.bins[0].index(s[0])
+	for i := 1; i < len(s); i++ {
+		res = res*d.number[i] + d.bins[i].index(s[i])
+	}
+	return res
+}
+
+func newBinning(spec string, thresh []float64, number int) (*binning, error) {
+	kind, param := spec, ""
+	if i := strings.Index(spec, "="); i >= 0 {
+		kind, param = spec[:i], spec[i+1:]
+	}
+
+	res := &binning{kind: kind, thresh: thresh, number: number}
+
+	minThresh, maxThresh := thresh[0], thresh[1]
+	inner := number - 2
+
+	switch kind {
+	case binsUniform:
+		if number < 3 {
+			return nil, fmt.Errorf("uniform bins needs number >= 3")
+		}
+
+	case binsEdges:
+		for _, str := range strings.Split(param, ",") {
+			v, err := strconv.ParseFloat(str, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid edge: %w", err)
+			}
+			res.edges = append(res.edges, v)
+		}
+		if !sort.Float64sAreSorted(res.edges) {
+			return nil, fmt.Errorf("edges must be ascending: %v", res.edges)
+		}
+		if len(res.edges)+1 != number {
+			return nil, fmt.Errorf("%d edges make %d bins, but number is %d",
+				len(res.edges), len(res.edges)+1, number)
+		}
+
+	case binsLog:
+		if minThresh <= 0 || inner < 1 {
+			return nil, fmt.Errorf("log bins needs 0 < min and number >= 3")
+		}
+		for j := 0; j <= inner; j++ {
+			res.edges = append(res.edges,
+				minThresh*math.Pow(maxThresh/minThresh, float64(j)/float64(inner)))
+		}
+
+	case binsSymmetric, binsSymlog:
+		if inner < 2 || inner%2 != 0 {
+			return nil, fmt.Errorf("%s bins needs an even number-2 >= 2", kind)
+		}
+
+		scale := defaultSymlogScale
+		if kind == binsSymlog && param != "" {
+			v, err := strconv.ParseFloat(param, 64)
+			if err != nil || v <= 0 {
+				return nil, fmt.Errorf("invalid symlog scale: %s", param)
+			}
+			scale = v
+		}
+
+		m := math.Max(math.Abs(minThresh), math.Abs(maxThresh))
+		half := inner / 2
+		positive := make([]float64, half)
+		for j := 1; j <= half; j++ {
+			x := float64(j) / float64(half)
+			if kind == binsSymlog {
+				x = (math.Pow(1.+scale, x) - 1.) / scale
+			}
+			positive[j-1] = m * x
+		}
+
+		for j := half - 1; j >= 0; j-- {
+			res.edges = append(res.edges, -positive[j])
+		}
+		res.edges = append(res.edges, 0)
+		res.edges = append(res.edges, positive...)
+
+	case binsAngle:
+		if number < 1 {
+			return nil, fmt.Errorf("angle bins needs number >= 1")
+		}
+
+	default:
+		return nil, fmt.Errorf("invalid state bins: %s", spec)
+	}
+
+	return res, nil
+}
+
+func (b *binning) index(v float64) int {
+	switch b.kind {
+	case binsUniform:
+		return digitize(v, b.thresh, b.number)
+	case binsAngle:
+		// Bin 0 is centered on zero so the upright angle is not on an edge.
+		width := 2. * math.Pi / float64(b.number)
+		u := math.Mod(v+width/2., 2.*math.Pi)
+		if u < 0 {
+			u += 2. * math.Pi
+		}
+		return int(u/width) % b.number
+	default:
+		return sort.Search(len(b.edges), func(i int) bool { return v < b.edges[i] })
+	}
+}
+
+// binEdges returns the edges of every dimension. The uniform and angle kinds
+// are expanded to explicit edges.
+func (d *discretizer) binEdges() [][]float64 {
+	res := make([][]float64, len(d.bins))
+	for i, b := range d.bins {
+		switch b.kind {
+		case binsUniform:
+			width := (b.thresh[1] - b.thresh[0]) / float64(b.number-2)
+			for j := 0; j <= b.number-2; j++ {
+				res[i] = append(res[i], b.thresh[0]+float64(j)*width)
+			}
+		case binsAngle:
+			width := 2. * math.Pi / float64(b.number)
+			for j := 0; j < b.number; j++ {
+				res[i] = append(res[i], -width/2.+float64(j)*width)
+			}
+		default:
+			res[i] = append([]float64(nil), b.edges...)
+		}
+	}
+	return res
+}
+
+func digitize(val float64, thresh []float64, number int) int {
+	minThresh := thresh[0]
+	maxThresh := thresh[1]
+
+	if val < minThresh {
+		return 0
+	} else if val >= maxThresh {
+		return number - 1
+	}
+
+	width := (maxThresh - minThresh) / float64(number-2)
+	return int((val-minThresh)/width) + 1
+}
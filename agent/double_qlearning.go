@@ -0,0 +1,81 @@
+package agent
+
+import (
+	"fmt"
+)
+
+// DoubleQLearning keeps two tables. One selects the greedy action of s2 and
+// the other evaluates it, which removes the max bootstrap overestimation.
+// QLearning.QTable is used as the first table.
+type DoubleQLearning struct {
+	QLearning
+
+	QTable2 [][]float64
+}
+
+func (dq *DoubleQLearning) Init() error {
+	if err := dq.QLearning.Init(); err != nil {
+		return fmt.Errorf("cannot init double qlearning: %w", err)
+	}
+
+	qtable, err := makeQTable(dq.stateSize, dq.actionSize, dq.Rand())
+	if err != nil {
+		return fmt.Errorf("cannot init double qlearning: %w", err)
+	}
+	dq.QTable2 = qtable
+
+	return nil
+}
+
+func (dq *DoubleQLearning) Action(s []float64) []float64 {
+	sIdx := dq.state.index(s)
+	idx := dq.policy().choose(dq.values(sIdx), sIdx, dq.Episodes)
+
+	return dq.actions[idx]
+}
+
+func (dq *DoubleQLearning) Learn(s1, a1 []float64, r float64, s2, a2 []float64) {
+	alpha := dq.alpha
+	gamma := dq.gamma
+
+	s1Idx := dq.state.index(s1)
+	s2Idx := dq.state.index(s2)
+
+	a1Idx := dq.actionsIndices[encodeFloat64Slice(a1)]
+
+	update, evaluate := dq.QTable, dq.QTable2
+	if dq.Rand().Intn(2) == 0 {
+		update, evaluate = evaluate, update
+	}
+
+	next := evaluate[s2Idx][argmax(update[s2Idx])]
+
+	update[s1Idx][a1Idx] =
+		(1.-alpha)*update[s1Idx][a1Idx] + alpha*(r+gamma*next)
+}
+
+func (dq *DoubleQLearning) Save(dst string) error {
+	return saveCheckpoint(dst, dq.header("Double-Q-Learning"), dq)
+}
+
+func (dq *DoubleQLearning) Load(src string) error {
+	var data DoubleQLearning
+	if err := loadCheckpoint(src, dq.header("Double-Q-Learning"), &data); err != nil {
+		return err
+	}
+
+	dq.QTable = data.QTable
+	dq.QTable2 = data.QTable2
+	dq.Episodes = data.Episodes
+
+	return nil
+}
+
+// values returns the sum of both tables for sIdx.
+func (dq *DoubleQLearning) values(sIdx int) []float64 {
+	res := make([]float64, dq.actionSize)
+	for i := range res {
+		res[i] = dq.QTable[sIdx][i] + dq.QTable2[sIdx][i]
+	}
+	return res
+}
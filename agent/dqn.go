@@ -0,0 +1,270 @@
+package agent
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	utils "github.com/high-moctane/lab_scup2020/utils"
+)
+
+// DQN is a deep Q-network agent with experience replay and a target network.
+// The continuous state is scaled into [-1, 1] by SCUP_AGENT_STATE_THRESH.
+type DQN struct {
+	randomness
+
+	gamma, eps, lr float64
+	eval           bool
+
+	stateThresh [][]float64 // [[min, max], [min, max], ...]
+	hidden      []int
+
+	actionSet
+
+	batchSize  int
+	trainStart int
+	targetSync int
+	replay     *replayBuffer
+
+	Online, Target *mlp
+	Steps          int
+	Episodes       int
+}
+
+func (dqn *DQN) Init() error {
+	dqn.InitRand()
+
+	if err := dqn.loadEnv(); err != nil {
+		return fmt.Errorf("cannot init dqn: %w", err)
+	}
+
+	sizes := []int{len(dqn.stateThresh)}
+	sizes = append(sizes, dqn.hidden...)
+	sizes = append(sizes, dqn.actionSize)
+
+	dqn.Online = newMLP(sizes, dqn.Rand())
+	dqn.Target = dqn.Online.clone()
+
+	dqn.Steps = 0
+	dqn.Episodes = 0
+
+	return nil
+}
+
+func (dqn *DQN) Reset() {
+	if dqn.eval {
+		return
+	}
+	dqn.Episodes += 1
+	lg.Info("dqn episode %d", dqn.Episodes)
+}
+
+func (dqn *DQN) Action(s []float64) []float64 {
+	var idx int
+	if !dqn.eval && dqn.Rand().Float64() < dqn.eps {
+		idx = dqn.Rand().Intn(dqn.actionSize)
+	} else {
+		idx = argmax(dqn.Online.predict(normalizeState(s, dqn.stateThresh)))
+	}
+
+	return dqn.actions[idx]
+}
+
+func (dqn *DQN) SetEval(eval bool) {
+	dqn.eval = eval
+}
+
+func (dqn *DQN) Epsilon() (float64, bool) {
+	if dqn.eval {
+		return 0., true
+	}
+	return dqn.eps, true
+}
+
+func (dqn *DQN) EpisodeCount() int {
+	return dqn.Episodes
+}
+
+func (dqn *DQN) Learn(s1, a1 []float64, r float64, s2, a2 []float64) {
+	dqn.replay.push(transition{s1, a1, r, s2, a2})
+	dqn.Steps++
+
+	if dqn.replay.len() < dqn.trainStart {
+		return
+	}
+
+	grad := dqn.Online.newGrad()
+	gradOut := make([]float64, dqn.actionSize)
+
+	for _, t := range dqn.replay.sample(dqn.batchSize, dqn.Rand()) {
+		q2 := dqn.Target.predict(normalizeState(t.s2, dqn.stateThresh))
+		y := t.r + dqn.gamma*q2[argmax(q2)]
+
+		acts := dqn.Online.forward(normalizeState(t.s1, dqn.stateThresh))
+		q1 := acts[len(acts)-1]
+		a1Idx := dqn.actionsIndices[encodeFloat64Slice(t.a1)]
+
+		for i := range gradOut {
+			gradOut[i] = 0
+		}
+		gradOut[a1Idx] = huberGrad(q1[a1Idx] - y)
+
+		dqn.Online.backward(acts, gradOut, grad)
+	}
+
+	dqn.Online.step(grad, dqn.lr, dqn.batchSize)
+
+	if dqn.Steps%dqn.targetSync == 0 {
+		dqn.Target.copyFrom(dqn.Online)
+	}
+}
+
+func (dqn *DQN) Save(dst string) error {
+	return saveCheckpoint(dst, dqn.header(), dqn)
+}
+
+func (dqn *DQN) Load(src string) error {
+	var data DQN
+	if err := loadCheckpoint(src, dqn.header(), &data); err != nil {
+		return err
+	}
+
+	if !dqn.Online.sameShape(data.Online) || !dqn.Online.sameShape(data.Target) {
+		return NewCheckpointMismatch(src, "network", dqn.Online.Sizes, data.Online.Sizes)
+	}
+
+	dqn.Online = data.Online
+	dqn.Target = data.Target
+	dqn.Steps = data.Steps
+	dqn.Episodes = data.Episodes
+
+	return nil
+}
+
+func (dqn *DQN) header() *checkpointHeader {
+	h := newCheckpointHeader("DQN")
+	h.StateThresh = dqn.stateThresh
+	h.Actions = dqn.actions
+	h.Shape["hidden"] = fmt.Sprint(dqn.hidden)
+	h.Params["gamma"] = fmt.Sprint(dqn.gamma)
+	h.Params["epsilon"] = fmt.Sprint(dqn.eps)
+	h.Params["learning rate"] = fmt.Sprint(dqn.lr)
+	h.Params["batch size"] = fmt.Sprint(dqn.batchSize)
+	h.Params["target sync"] = fmt.Sprint(dqn.targetSync)
+	h.Episodes = dqn.Episodes
+	return h
+}
+
+// huberGrad is the derivative of the Huber loss with delta = 1.
+func huberGrad(x float64) float64 {
+	return math.Max(-1., math.Min(1., x))
+}
+
+func (dqn *DQN) loadEnv() error {
+	if err := dqn.loadParamsEnv(); err != nil {
+		return fmt.Errorf("cannot load env: %w", err)
+	}
+	if err := dqn.loadNetworkEnv(); err != nil {
+		return fmt.Errorf("cannot load env: %w", err)
+	}
+	if err := dqn.loadActionEnv(); err != nil {
+		return fmt.Errorf("cannot load env: %w", err)
+	}
+	return nil
+}
+
+func (dqn *DQN) loadParamsEnv() error {
+	gamma, err := utils.GetEnvFloat64("SCUP_AGENT_GAMMA")
+	if err != nil {
+		return fmt.Errorf("cannot load params env: %w", err)
+	}
+
+	eps, err := utils.GetEnvFloat64("SCUP_AGENT_EPSILON")
+	if err != nil {
+		return fmt.Errorf("cannot load params env: %w", err)
+	}
+
+	lr, err := utils.GetEnvFloat64("SCUP_AGENT_DQN_LEARNING_RATE")
+	if err != nil {
+		return fmt.Errorf("cannot load params env: %w", err)
+	}
+
+	batchSize, err := utils.GetEnvInt("SCUP_AGENT_DQN_BATCH_SIZE")
+	if err != nil {
+		return fmt.Errorf("cannot load params env: %w", err)
+	}
+
+	replaySize, err := utils.GetEnvInt("SCUP_AGENT_DQN_REPLAY_SIZE")
+	if err != nil {
+		return fmt.Errorf("cannot load params env: %w", err)
+	}
+
+	trainStart, err := utils.GetEnvInt("SCUP_AGENT_DQN_TRAIN_START")
+	if err != nil {
+		return fmt.Errorf("cannot load params env: %w", err)
+	}
+
+	targetSync, err := utils.GetEnvInt("SCUP_AGENT_DQN_TARGET_SYNC")
+	if err != nil {
+		return fmt.Errorf("cannot load params env: %w", err)
+	}
+
+	if batchSize < 1 || replaySize < 1 || targetSync < 1 {
+		return fmt.Errorf("batch size, replay size and target sync must be positive")
+	}
+	if trainStart < batchSize {
+		trainStart = batchSize
+	}
+
+	dqn.gamma = gamma
+	dqn.eps = eps
+	dqn.lr = lr
+	dqn.batchSize = batchSize
+	dqn.trainStart = trainStart
+	dqn.targetSync = targetSync
+	dqn.replay = newReplayBuffer(replaySize)
+
+	return nil
+}
+
+func (dqn *DQN) loadNetworkEnv() error {
+	stateThresh, err := parseStateThresh()
+	if err != nil {
+		return fmt.Errorf("cannot load network env: %w", err)
+	}
+
+	hidden, err := parseHiddenLayers()
+	if err != nil {
+		return fmt.Errorf("cannot load network env: %w", err)
+	}
+
+	dqn.stateThresh = stateThresh
+	dqn.hidden = hidden
+
+	return nil
+}
+
+func parseHiddenLayers() ([]int, error) {
+	str, ok := os.LookupEnv("SCUP_AGENT_HIDDEN")
+	if !ok {
+		return nil, fmt.Errorf("cannot find SCUP_AGENT_HIDDEN")
+	}
+
+	res := []int{}
+
+	for _, elem := range strings.Split(str, ":") {
+		v, err := strconv.Atoi(elem)
+		if err != nil {
+			return nil, fmt.Errorf("invalid hidden layer size: %w", err)
+		}
+		if v < 1 {
+			return nil, fmt.Errorf("invalid hidden layer size: %d", v)
+		}
+
+		res = append(res, v)
+	}
+
+	return res, nil
+}
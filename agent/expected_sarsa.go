@@ -0,0 +1,44 @@
+package agent
+
+import (
+	"fmt"
+)
+
+// ExpectedSARSA bootstraps from the expectation of Q(s2, ·) under the
+// exploration policy instead of a single sampled action.
+type ExpectedSARSA struct {
+	QLearning
+}
+
+func (es *ExpectedSARSA) Init() error {
+	if err := es.QLearning.Init(); err != nil {
+		return fmt.Errorf("cannot init expected sarsa: %w", err)
+	}
+	return nil
+}
+
+func (es *ExpectedSARSA) Learn(s1, a1 []float64, r float64, s2, a2 []float64) {
+	alpha := es.alpha
+	gamma := es.gamma
+
+	s1Idx := es.state.index(s1)
+	s2Idx := es.state.index(s2)
+
+	a1Idx := es.actionsIndices[encodeFloat64Slice(a1)]
+
+	expected := 0.
+	for i, p := range es.explorer.probs(es.QTable[s2Idx], s2Idx, es.Episodes) {
+		expected += p * es.QTable[s2Idx][i]
+	}
+
+	es.QTable[s1Idx][a1Idx] =
+		(1.-alpha)*es.QTable[s1Idx][a1Idx] + alpha*(r+gamma*expected)
+}
+
+func (es *ExpectedSARSA) Save(dst string) error {
+	return es.save(dst, es.header("Expected-SARSA"))
+}
+
+func (es *ExpectedSARSA) Load(src string) error {
+	return es.load(src, es.header("Expected-SARSA"))
+}
@@ -0,0 +1,255 @@
+package agent
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+
+	utils "github.com/high-moctane/lab_scup2020/utils"
+)
+
+// explorer chooses an action index from the action values of a state.
+// sIdx is the discretized state index and episode is the number of episodes
+// the agent has run.
+type explorer interface {
+	choose(values []float64, sIdx, episode int) int
+	probs(values []float64, sIdx, episode int) []float64
+}
+
+// newExplorer makes the explorer selected by SCUP_AGENT_EXPLORATION.
+// Constant epsilon-greedy is the default.
+func newExplorer(stateSize, actionSize int, rng *rand.Rand) (explorer, error) {
+	name := utils.GetEnvStringDefault("SCUP_AGENT_EXPLORATION", "EpsilonGreedy")
+
+	var res explorer
+	var err error
+
+	switch name {
+	case "EpsilonGreedy":
+		res, err = newEpsilonGreedy(rng)
+	case "Boltzmann":
+		res, err = newBoltzmann(rng)
+	case "UCB":
+		res, err = newUCB(stateSize, actionSize)
+	case "Greedy":
+		res = greedy{}
+	default:
+		return nil, fmt.Errorf("invalid exploration: %s", name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot make explorer: %w", err)
+	}
+
+	return res, nil
+}
+
+// schedule decays a value by episode. "linear" reaches min after rate
+// episodes, "exponential" multiplies by rate every episode.
+type schedule struct {
+	init, min float64
+	decay     string
+	rate      float64
+}
+
+func newSchedule(prefix string) (*schedule, error) {
+	init, err := utils.GetEnvFloat64(prefix)
+	if err != nil {
+		return nil, fmt.Errorf("cannot make schedule: %w", err)
+	}
+
+	decay := utils.GetEnvStringDefault(prefix+"_DECAY", "none")
+
+	res := &schedule{init: init, min: init, decay: decay}
+
+	switch decay {
+	case "none":
+		return res, nil
+	case "linear", "exponential":
+	default:
+		return nil, fmt.Errorf("invalid %s_DECAY: %s", prefix, decay)
+	}
+
+	res.min, err = utils.GetEnvFloat64(prefix + "_MIN")
+	if err != nil {
+		return nil, fmt.Errorf("cannot make schedule: %w", err)
+	}
+
+	res.rate, err = utils.GetEnvFloat64(prefix + "_DECAY_RATE")
+	if err != nil {
+		return nil, fmt.Errorf("cannot make schedule: %w", err)
+	}
+
+	return res, nil
+}
+
+func (sc *schedule) value(episode int) float64 {
+	switch sc.decay {
+	case "linear":
+		if sc.rate <= 0 {
+			return sc.min
+		}
+		return math.Max(sc.min, sc.init-(sc.init-sc.min)*float64(episode)/sc.rate)
+	case "exponential":
+		return math.Max(sc.min, sc.init*math.Pow(sc.rate, float64(episode)))
+	default:
+		return sc.init
+	}
+}
+
+// explorerEpsilon returns epsilon of e at episode. Greedy is epsilon-greedy
+// with zero epsilon.
+func explorerEpsilon(e explorer, episode int) (float64, bool) {
+	switch e := e.(type) {
+	case *epsilonGreedy:
+		return e.eps.value(episode), true
+	case greedy:
+		return 0., true
+	default:
+		return 0., false
+	}
+}
+
+type epsilonGreedy struct {
+	eps *schedule
+	rng *rand.Rand
+}
+
+func newEpsilonGreedy(rng *rand.Rand) (*epsilonGreedy, error) {
+	eps, err := newSchedule("SCUP_AGENT_EPSILON")
+	if err != nil {
+		return nil, fmt.Errorf("cannot make epsilon greedy: %w", err)
+	}
+	return &epsilonGreedy{eps, rng}, nil
+}
+
+func (eg *epsilonGreedy) choose(values []float64, sIdx, episode int) int {
+	if eg.rng.Float64() < eg.eps.value(episode) {
+		return eg.rng.Intn(len(values))
+	}
+	return argmax(values)
+}
+
+func (eg *epsilonGreedy) probs(values []float64, sIdx, episode int) []float64 {
+	eps := eg.eps.value(episode)
+
+	res := make([]float64, len(values))
+	for i := range res {
+		res[i] = eps / float64(len(values))
+	}
+	res[argmax(values)] += 1. - eps
+
+	return res
+}
+
+type boltzmann struct {
+	temperature *schedule
+	rng         *rand.Rand
+}
+
+func newBoltzmann(rng *rand.Rand) (*boltzmann, error) {
+	temperature, err := newSchedule("SCUP_AGENT_TEMPERATURE")
+	if err != nil {
+		return nil, fmt.Errorf("cannot make boltzmann: %w", err)
+	}
+	return &boltzmann{temperature, rng}, nil
+}
+
+func (bo *boltzmann) choose(values []float64, sIdx, episode int) int {
+	p := bo.probs(values, sIdx, episode)
+
+	x := bo.rng.Float64()
+	for i, v := range p {
+		x -= v
+		if x < 0 {
+			return i
+		}
+	}
+	return len(p) - 1
+}
+
+func (bo *boltzmann) probs(values []float64, sIdx, episode int) []float64 {
+	temperature := bo.temperature.value(episode)
+	max := values[argmax(values)]
+
+	res := make([]float64, len(values))
+	if temperature <= 0 {
+		res[argmax(values)] = 1.
+		return res
+	}
+
+	sum := 0.
+	for i, v := range values {
+		res[i] = math.Exp((v - max) / temperature)
+		sum += res[i]
+	}
+	for i := range res {
+		res[i] /= sum
+	}
+
+	return res
+}
+
+// ucb picks the action maximizing Q(s, a) + c * sqrt(ln N(s) / N(s, a)).
+// Untried actions are chosen first.
+type ucb struct {
+	c          float64
+	actionSize int
+	counts     []int // [state*actionSize+action]
+}
+
+func newUCB(stateSize, actionSize int) (*ucb, error) {
+	if stateSize <= 0 {
+		return nil, fmt.Errorf("ucb needs a discrete state")
+	}
+
+	c, err := utils.GetEnvFloat64("SCUP_AGENT_UCB_C")
+	if err != nil {
+		return nil, fmt.Errorf("cannot make ucb: %w", err)
+	}
+
+	return &ucb{c, actionSize, make([]int, stateSize*actionSize)}, nil
+}
+
+func (u *ucb) choose(values []float64, sIdx, episode int) int {
+	res := u.best(values, sIdx)
+	u.counts[sIdx*u.actionSize+res]++
+	return res
+}
+
+func (u *ucb) probs(values []float64, sIdx, episode int) []float64 {
+	res := make([]float64, len(values))
+	res[u.best(values, sIdx)] = 1.
+	return res
+}
+
+func (u *ucb) best(values []float64, sIdx int) int {
+	counts := u.counts[sIdx*u.actionSize : (sIdx+1)*u.actionSize]
+
+	total := 0
+	for i, n := range counts {
+		if n == 0 {
+			return i
+		}
+		total += n
+	}
+
+	scores := make([]float64, len(values))
+	for i, v := range values {
+		scores[i] = v + u.c*math.Sqrt(math.Log(float64(total))/float64(counts[i]))
+	}
+
+	return argmax(scores)
+}
+
+// greedy never explores. It is meant for evaluation.
+type greedy struct{}
+
+func (greedy) choose(values []float64, sIdx, episode int) int {
+	return argmax(values)
+}
+
+func (greedy) probs(values []float64, sIdx, episode int) []float64 {
+	res := make([]float64, len(values))
+	res[argmax(values)] = 1.
+	return res
+}
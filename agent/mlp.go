@@ -0,0 +1,216 @@
+package agent
+
+import (
+	"math"
+	"math/rand"
+)
+
+// mlp is a fully connected network with ReLU hidden layers and a linear
+// output layer, trained with Adam.
+type mlp struct {
+	Sizes []int
+	W     [][]float64 // W[l][o*Sizes[l]+i]
+	B     [][]float64
+
+	// Adam moments
+	MW, VW, MB, VB [][]float64
+	T              int
+}
+
+// mlpGrad holds gradients of the parameters of an mlp.
+type mlpGrad struct {
+	W, B [][]float64
+}
+
+const (
+	adamBeta1   = 0.9
+	adamBeta2   = 0.999
+	adamEpsilon = 1e-8
+)
+
+func newMLP(sizes []int, rng *rand.Rand) *mlp {
+	res := &mlp{Sizes: sizes}
+
+	res.W = make([][]float64, len(sizes)-1)
+	res.B = make([][]float64, len(sizes)-1)
+	for l := 0; l < len(sizes)-1; l++ {
+		res.W[l] = make([]float64, sizes[l+1]*sizes[l])
+		res.B[l] = make([]float64, sizes[l+1])
+
+		// He uniform
+		limit := math.Sqrt(6. / float64(sizes[l]))
+		for i := range res.W[l] {
+			res.W[l][i] = (rng.Float64()*2. - 1.) * limit
+		}
+	}
+
+	res.MW, res.VW = zerosLike(res.W), zerosLike(res.W)
+	res.MB, res.VB = zerosLike(res.B), zerosLike(res.B)
+
+	return res
+}
+
+func zerosLike(x [][]float64) [][]float64 {
+	res := make([][]float64, len(x))
+	for i := range x {
+		res[i] = make([]float64, len(x[i]))
+	}
+	return res
+}
+
+func copyFloat64Matrix(x [][]float64) [][]float64 {
+	res := make([][]float64, len(x))
+	for i := range x {
+		res[i] = append([]float64(nil), x[i]...)
+	}
+	return res
+}
+
+func (m *mlp) clone() *mlp {
+	return &mlp{
+		Sizes: append([]int(nil), m.Sizes...),
+		W:     copyFloat64Matrix(m.W),
+		B:     copyFloat64Matrix(m.B),
+		MW:    copyFloat64Matrix(m.MW),
+		VW:    copyFloat64Matrix(m.VW),
+		MB:    copyFloat64Matrix(m.MB),
+		VB:    copyFloat64Matrix(m.VB),
+		T:     m.T,
+	}
+}
+
+// copyFrom copies the weights of src into m.
+func (m *mlp) copyFrom(src *mlp) {
+	for l := range m.W {
+		copy(m.W[l], src.W[l])
+		copy(m.B[l], src.B[l])
+	}
+}
+
+// softUpdate moves the weights of m toward src by tau.
+func (m *mlp) softUpdate(src *mlp, tau float64) {
+	for l := range m.W {
+		for i := range m.W[l] {
+			m.W[l][i] += tau * (src.W[l][i] - m.W[l][i])
+		}
+		for i := range m.B[l] {
+			m.B[l][i] += tau * (src.B[l][i] - m.B[l][i])
+		}
+	}
+}
+
+func (m *mlp) newGrad() *mlpGrad {
+	return &mlpGrad{zerosLike(m.W), zerosLike(m.B)}
+}
+
+// forward returns the activations of every layer. The first element is x and
+// the last one is the output.
+func (m *mlp) forward(x []float64) [][]float64 {
+	acts := make([][]float64, len(m.Sizes))
+	acts[0] = x
+
+	for l := 0; l < len(m.W); l++ {
+		in := acts[l]
+		out := make([]float64, m.Sizes[l+1])
+		for o := range out {
+			sum := m.B[l][o]
+			row := m.W[l][o*m.Sizes[l] : (o+1)*m.Sizes[l]]
+			for i, v := range in {
+				sum += row[i] * v
+			}
+			if l < len(m.W)-1 && sum < 0 {
+				sum = 0
+			}
+			out[o] = sum
+		}
+		acts[l+1] = out
+	}
+
+	return acts
+}
+
+func (m *mlp) predict(x []float64) []float64 {
+	acts := m.forward(x)
+	return acts[len(acts)-1]
+}
+
+// backward accumulates the parameter gradients for the output gradient gradOut
+// into grad and returns the gradient with respect to the input.
+func (m *mlp) backward(acts [][]float64, gradOut []float64, grad *mlpGrad) []float64 {
+	delta := append([]float64(nil), gradOut...)
+
+	for l := len(m.W) - 1; l >= 0; l-- {
+		in := acts[l]
+		gradIn := make([]float64, m.Sizes[l])
+
+		for o, d := range delta {
+			if d == 0 {
+				continue
+			}
+			grad.B[l][o] += d
+			row := m.W[l][o*m.Sizes[l] : (o+1)*m.Sizes[l]]
+			gradRow := grad.W[l][o*m.Sizes[l] : (o+1)*m.Sizes[l]]
+			for i, v := range in {
+				gradRow[i] += d * v
+				gradIn[i] += d * row[i]
+			}
+		}
+
+		// ReLU derivative of the previous hidden layer
+		if l > 0 {
+			for i, v := range in {
+				if v <= 0 {
+					gradIn[i] = 0
+				}
+			}
+		}
+
+		delta = gradIn
+	}
+
+	return delta
+}
+
+// step applies Adam with the mean of grad over batchSize samples.
+func (m *mlp) step(grad *mlpGrad, lr float64, batchSize int) {
+	m.T++
+	scale := 1. / float64(batchSize)
+	c1 := 1. - math.Pow(adamBeta1, float64(m.T))
+	c2 := 1. - math.Pow(adamBeta2, float64(m.T))
+
+	update := func(p, g, mo, v []float64) {
+		for i := range p {
+			gi := g[i] * scale
+			mo[i] = adamBeta1*mo[i] + (1.-adamBeta1)*gi
+			v[i] = adamBeta2*v[i] + (1.-adamBeta2)*gi*gi
+			p[i] -= lr * (mo[i] / c1) / (math.Sqrt(v[i]/c2) + adamEpsilon)
+		}
+	}
+
+	for l := range m.W {
+		update(m.W[l], grad.W[l], m.MW[l], m.VW[l])
+		update(m.B[l], grad.B[l], m.MB[l], m.VB[l])
+	}
+}
+
+func (m *mlp) sameShape(other *mlp) bool {
+	if other == nil || len(m.Sizes) != len(other.Sizes) {
+		return false
+	}
+	for i := range m.Sizes {
+		if m.Sizes[i] != other.Sizes[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// normalizeState scales s into [-1, 1] by thresh, the [min, max] of each
+// dimension from SCUP_AGENT_STATE_THRESH.
+func normalizeState(s []float64, thresh [][]float64) []float64 {
+	res := make([]float64, len(s))
+	for i, v := range s {
+		res[i] = 2.*(v-thresh[i][0])/(thresh[i][1]-thresh[i][0]) - 1.
+	}
+	return res
+}
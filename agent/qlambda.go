@@ -0,0 +1,71 @@
+package agent
+
+import (
+	"fmt"
+)
+
+// QLambda is Watkins's Q(lambda). Traces are cut whenever a non-greedy
+// action is taken.
+type QLambda struct {
+	QLearning
+
+	traces *eligibilityTraces
+}
+
+func (ql *QLambda) Init() error {
+	if err := ql.QLearning.Init(); err != nil {
+		return fmt.Errorf("cannot init q(lambda): %w", err)
+	}
+
+	traces, err := newEligibilityTraces()
+	if err != nil {
+		return fmt.Errorf("cannot init q(lambda): %w", err)
+	}
+	ql.traces = traces
+
+	return nil
+}
+
+func (ql *QLambda) Reset() {
+	ql.QLearning.Reset()
+	ql.traces.reset()
+}
+
+func (ql *QLambda) Learn(s1, a1 []float64, r float64, s2, a2 []float64) {
+	alpha := ql.alpha
+	gamma := ql.gamma
+
+	s1Idx := ql.state.index(s1)
+	s2Idx := ql.state.index(s2)
+
+	a1Idx := ql.actionsIndices[encodeFloat64Slice(a1)]
+	a2Idx := ql.actionsIndices[encodeFloat64Slice(a2)]
+
+	max := ql.QTable[s2Idx][argmax(ql.QTable[s2Idx])]
+	isGreedy := ql.QTable[s2Idx][a2Idx] == max
+
+	delta := r + gamma*max - ql.QTable[s1Idx][a1Idx]
+
+	ql.traces.visit(s1Idx, a1Idx, ql.actionSize)
+	ql.traces.update(ql.QTable, alpha*delta)
+
+	if isGreedy {
+		ql.traces.decay(gamma)
+	} else {
+		ql.traces.reset()
+	}
+}
+
+func (ql *QLambda) Save(dst string) error {
+	return ql.save(dst, ql.header())
+}
+
+func (ql *QLambda) Load(src string) error {
+	return ql.load(src, ql.header())
+}
+
+func (ql *QLambda) header() *checkpointHeader {
+	h := ql.QLearning.header("Q-Lambda")
+	ql.traces.describe(h)
+	return h
+}
@@ -1,22 +1,16 @@
 package agent
 
 import (
-	"bufio"
-	"bytes"
-	"encoding/gob"
-	"errors"
 	"fmt"
-	"log"
 	"math/rand"
 	"os"
 	"strconv"
 	"strings"
 
-	_ "github.com/high-moctane/lab_scup2020/logger"
 	utils "github.com/high-moctane/lab_scup2020/utils"
 )
 
-func makeQTable(stateSize, actionSize int) ([][]float64, error) {
+func makeQTable(stateSize, actionSize int, rng *rand.Rand) ([][]float64, error) {
 	initQ, err := utils.GetEnvFloat64("SCUP_AGENT_INIT_QVALUE")
 	if err != nil {
 		return nil, fmt.Errorf("cannot init qtable: %w", err)
@@ -26,7 +20,7 @@ func makeQTable(stateSize, actionSize int) ([][]float64, error) {
 	for i := 0; i < stateSize; i++ {
 		res[i] = make([]float64, actionSize)
 		for j := 0; j < actionSize; j++ {
-			res[i][j] = initQ + rand.Float64()*0.01
+			res[i][j] = initQ + rng.Float64()*0.01
 		}
 	}
 
@@ -115,34 +109,6 @@ func parseActions() ([][]float64, error) {
 	return res, nil
 }
 
-func getStateIndex(thresh [][]float64, number []int, s []float64) int {
-	indices := []int{}
-	for i, v := range s {
-		indices = append(indices, digitize(v, thresh[i], number[i]))
-	}
-
-	res := indices[0]
-	for i := 1; i < len(s); i++ {
-		res = res*number[i] + indices[i]
-	}
-
-	return res
-}
-
-func digitize(val float64, thresh []float64, number int) int {
-	minThresh := thresh[0]
-	maxThresh := thresh[1]
-
-	if val < minThresh {
-		return 0
-	} else if val >= maxThresh {
-		return number - 1
-	}
-
-	width := (maxThresh - minThresh) / float64(number-2)
-	return int((val-minThresh)/width) + 1
-}
-
 func argmax(values []float64) int {
 	res := 0
 	for i := 1; i < len(values); i++ {
@@ -158,59 +124,86 @@ func encodeFloat64Slice(slice []float64) string {
 }
 
 type QLearning struct {
-	alpha, gamma, eps float64
+	randomness
+
+	alpha, gamma float64
+	explorer     explorer
+	eval         bool
 
-	stateSize   int
-	stateThresh [][]float64 // [[min, max], [min, max], ...]
-	stateNumber []int
+	stateSize int
+	state     *discretizer
 
-	actionSize     int
-	actions        [][]float64
-	actionsIndices map[string]int
+	actionSet
 
 	QTable   [][]float64
 	Episodes int
 }
 
 func (ql *QLearning) Init() error {
+	ql.InitRand()
+
 	if err := ql.loadEnv(); err != nil {
 		return fmt.Errorf("cannot init qlearning: %w", err)
 	}
 
-	qtable, err := makeQTable(ql.stateSize, ql.actionSize)
+	qtable, err := makeQTable(ql.stateSize, ql.actionSize, ql.Rand())
 	if err != nil {
 		return fmt.Errorf("cannot init qlearning: %w", err)
 	}
 	ql.QTable = qtable
 
+	explorer, err := newExplorer(ql.stateSize, ql.actionSize, ql.Rand())
+	if err != nil {
+		return fmt.Errorf("cannot init qlearning: %w", err)
+	}
+	ql.explorer = explorer
+
 	ql.Episodes = 0
 
 	return nil
 }
 
 func (ql *QLearning) Reset() {
+	if ql.eval {
+		return
+	}
 	ql.Episodes += 1
-	log.Printf("qlearning episode %d", ql.Episodes)
+	lg.Info("qlearning episode %d", ql.Episodes)
 }
 
 func (ql *QLearning) Action(s []float64) []float64 {
-	var idx int
-	if rand.Float64() < ql.eps {
-		idx = rand.Intn(ql.actionSize)
-	} else {
-		sIdx := getStateIndex(ql.stateThresh, ql.stateNumber, s)
-		idx = argmax(ql.QTable[sIdx])
-	}
+	sIdx := ql.state.index(s)
+	idx := ql.policy().choose(ql.QTable[sIdx], sIdx, ql.Episodes)
 
 	return ql.actions[idx]
 }
 
+func (ql *QLearning) SetEval(eval bool) {
+	ql.eval = eval
+}
+
+func (ql *QLearning) Epsilon() (float64, bool) {
+	return explorerEpsilon(ql.policy(), ql.Episodes)
+}
+
+func (ql *QLearning) EpisodeCount() int {
+	return ql.Episodes
+}
+
+// policy returns the explorer in use, which is greedy while evaluating.
+func (ql *QLearning) policy() explorer {
+	if ql.eval {
+		return greedy{}
+	}
+	return ql.explorer
+}
+
 func (ql *QLearning) Learn(s1, a1 []float64, r float64, s2, a2 []float64) {
 	alpha := ql.alpha
 	gamma := ql.gamma
 
-	s1Idx := getStateIndex(ql.stateThresh, ql.stateNumber, s1)
-	s2Idx := getStateIndex(ql.stateThresh, ql.stateNumber, s2)
+	s1Idx := ql.state.index(s1)
+	s2Idx := ql.state.index(s2)
 
 	a1Idx := ql.actionsIndices[encodeFloat64Slice(a1)]
 
@@ -226,57 +219,65 @@ func (ql *QLearning) Learn(s1, a1 []float64, r float64, s2, a2 []float64) {
 }
 
 func (ql *QLearning) Save(dst string) error {
-	f, err := os.Create(dst)
-	if err != nil {
-		return fmt.Errorf("cannot save qlearning to %s: %w", dst, err)
-	}
-	defer f.Close()
+	return ql.save(dst, ql.header("Q-Learning"))
+}
 
-	buf := bytes.NewBuffer(nil)
+func (ql *QLearning) Load(src string) error {
+	return ql.load(src, ql.header("Q-Learning"))
+}
 
-	err = gob.NewEncoder(buf).Encode(ql)
-	if err != nil {
-		return fmt.Errorf("cannot save qlearning to %s: %w", dst, err)
-	}
+// header describes ql as the agent name. Agents built on QLearning add their
+// own params to it.
+func (ql *QLearning) header(name string) *checkpointHeader {
+	h := newCheckpointHeader(name)
+	h.StateThresh = ql.state.thresh
+	h.StateNumber = ql.state.number
+	h.StateBins = ql.state.specs
+	h.Actions = ql.actions
+	h.Params["alpha"] = fmt.Sprint(ql.alpha)
+	h.Params["gamma"] = fmt.Sprint(ql.gamma)
+	h.Episodes = ql.Episodes
+	return h
+}
 
-	w := bufio.NewWriter(f)
-	defer w.Flush()
+func (ql *QLearning) save(dst string, h *checkpointHeader) error {
+	return saveCheckpoint(dst, h, ql)
+}
 
-	if _, err := w.Write(buf.Bytes()); err != nil {
-		return fmt.Errorf("cannot save qlearning to %s: %w", dst, err)
+func (ql *QLearning) load(src string, h *checkpointHeader) error {
+	if h.Agent == "Q-Learning" {
+		if data, ok := loadLegacyTable(src); ok {
+			return ql.loadLegacy(src, data)
+		}
+	}
+
+	var data QLearning
+	if err := loadCheckpoint(src, h, &data); err != nil {
+		return err
 	}
 
-	// logger.Get().Info("agent saved to %s", dst)
-	log.Printf("agent save to %s", dst)
+	ql.QTable = data.QTable
+	ql.Episodes = data.Episodes
 
 	return nil
 }
 
-func (ql *QLearning) Load(src string) error {
-	pathError := new(os.PathError)
-
-	f, err := os.Open(src)
-	if err != nil {
-		if errors.As(err, &pathError) {
-			return NewAgentDataNotFound(src)
-		} else {
-			return fmt.Errorf("cannot load qlearning from %s: %w", src, err)
-		}
+// loadLegacy loads the unversioned data which QLearning wrote before
+// checkpoints. Only the shape of the table can be checked against the config.
+func (ql *QLearning) loadLegacy(src string, data *legacyTable) error {
+	if len(data.QTable) != ql.state.size() {
+		return NewCheckpointMismatch(src, "state size", ql.state.size(), len(data.QTable))
 	}
-	defer f.Close()
-
-	r := bufio.NewReader(f)
-
-	var data QLearning
-	if err := gob.NewDecoder(r).Decode(&data); err != nil {
-		return fmt.Errorf("cannot load qlearning from %s: %w", src, err)
+	for _, row := range data.QTable {
+		if len(row) != len(ql.actions) {
+			return NewCheckpointMismatch(src, "action size", len(ql.actions), len(row))
+		}
 	}
 
 	ql.QTable = data.QTable
 	ql.Episodes = data.Episodes
 
-	// logger.Get().Info("agent loaded to %s", src)
-	log.Printf("agent load from %s", src)
+	lg.Warn("agent %s: loaded unversioned data, it is saved as a checkpoint from now on", src)
 
 	return nil
 }
@@ -305,64 +306,20 @@ func (ql *QLearning) loadParamsEnv() error {
 		return fmt.Errorf("cannot load params env: %w", err)
 	}
 
-	eps, err := utils.GetEnvFloat64("SCUP_AGENT_EPSILON")
-	if err != nil {
-		return fmt.Errorf("cannot load params env: %w", err)
-	}
-
 	ql.alpha = alpha
 	ql.gamma = gamma
-	ql.eps = eps
 
 	return nil
 }
 
 func (ql *QLearning) loadStateEnv() error {
-	stateThresh, err := parseStateThresh()
+	state, err := newDiscretizer()
 	if err != nil {
 		return fmt.Errorf("cannot load state env: %w", err)
 	}
 
-	stateNumber, err := parseStateNumber()
-	if err != nil {
-		return fmt.Errorf("cannot load state env: %w", err)
-	}
-
-	if len(stateThresh) != len(stateNumber) {
-		return fmt.Errorf("len(stateThresh) == %v, but len(stateNumber) == %v",
-			len(stateThresh), len(stateNumber))
-	}
-
-	stateSize := 1
-	for _, n := range stateNumber {
-		stateSize *= n
-	}
-
-	ql.stateSize = stateSize
-	ql.stateThresh = stateThresh
-	ql.stateNumber = stateNumber
-
-	return nil
-}
-
-func (ql *QLearning) loadActionEnv() error {
-	actions, err := parseActions()
-	if err != nil {
-		return fmt.Errorf("cannot load actions: %w", err)
-	}
-
-	actionsIndices := map[string]int{}
-	for i, a := range actions {
-		aEnc := encodeFloat64Slice(a)
-		if _, ok := actionsIndices[aEnc]; ok {
-			return fmt.Errorf("duplicate action: %v", a)
-		}
-		actionsIndices[aEnc] = i
-	}
-
-	ql.actionSize = len(actions)
-	ql.actions = actions
-	ql.actionsIndices = actionsIndices
+	ql.stateSize = state.size()
+	ql.state = state
 
 	return nil
 }
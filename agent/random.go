@@ -0,0 +1,9 @@
+package agent
+
+import (
+	utils "github.com/high-moctane/lab_scup2020/utils"
+)
+
+// randomness is the random number generator of an agent. The alias keeps the
+// embedded field unexported, so that gob leaves it out of checkpoints.
+type randomness = utils.Randomness
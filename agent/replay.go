@@ -0,0 +1,53 @@
+package agent
+
+import (
+	"math/rand"
+)
+
+type transition struct {
+	s1, a1 []float64
+	r      float64
+	s2, a2 []float64
+}
+
+// replayBuffer is a fixed size ring buffer of transitions.
+type replayBuffer struct {
+	buf  []transition
+	size int
+	next int
+}
+
+func newReplayBuffer(size int) *replayBuffer {
+	return &replayBuffer{buf: make([]transition, 0, size), size: size}
+}
+
+func (rb *replayBuffer) len() int {
+	return len(rb.buf)
+}
+
+// push stores a copy of t.
+func (rb *replayBuffer) push(t transition) {
+	t = transition{
+		append([]float64(nil), t.s1...),
+		append([]float64(nil), t.a1...),
+		t.r,
+		append([]float64(nil), t.s2...),
+		append([]float64(nil), t.a2...),
+	}
+
+	if len(rb.buf) < rb.size {
+		rb.buf = append(rb.buf, t)
+	} else {
+		rb.buf[rb.next] = t
+	}
+	rb.next = (rb.next + 1) % rb.size
+}
+
+// sample returns n transitions chosen uniformly with replacement.
+func (rb *replayBuffer) sample(n int, rng *rand.Rand) []transition {
+	res := make([]transition, n)
+	for i := range res {
+		res[i] = rb.buf[rng.Intn(len(rb.buf))]
+	}
+	return res
+}
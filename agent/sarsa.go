@@ -0,0 +1,41 @@
+package agent
+
+import (
+	"fmt"
+)
+
+// SARSA is an on-policy TD control agent. It shares the discretization,
+// action set and persistence of QLearning but bootstraps from the action
+// actually taken in s2.
+type SARSA struct {
+	QLearning
+}
+
+func (sa *SARSA) Init() error {
+	if err := sa.QLearning.Init(); err != nil {
+		return fmt.Errorf("cannot init sarsa: %w", err)
+	}
+	return nil
+}
+
+func (sa *SARSA) Learn(s1, a1 []float64, r float64, s2, a2 []float64) {
+	alpha := sa.alpha
+	gamma := sa.gamma
+
+	s1Idx := sa.state.index(s1)
+	s2Idx := sa.state.index(s2)
+
+	a1Idx := sa.actionsIndices[encodeFloat64Slice(a1)]
+	a2Idx := sa.actionsIndices[encodeFloat64Slice(a2)]
+
+	sa.QTable[s1Idx][a1Idx] =
+		(1.-alpha)*sa.QTable[s1Idx][a1Idx] + alpha*(r+gamma*sa.QTable[s2Idx][a2Idx])
+}
+
+func (sa *SARSA) Save(dst string) error {
+	return sa.save(dst, sa.header("SARSA"))
+}
+
+func (sa *SARSA) Load(src string) error {
+	return sa.load(src, sa.header("SARSA"))
+}
@@ -0,0 +1,62 @@
+package agent
+
+import (
+	"fmt"
+)
+
+// SARSALambda is SARSA(lambda) over the QLearning table.
+type SARSALambda struct {
+	QLearning
+
+	traces *eligibilityTraces
+}
+
+func (sl *SARSALambda) Init() error {
+	if err := sl.QLearning.Init(); err != nil {
+		return fmt.Errorf("cannot init sarsa(lambda): %w", err)
+	}
+
+	traces, err := newEligibilityTraces()
+	if err != nil {
+		return fmt.Errorf("cannot init sarsa(lambda): %w", err)
+	}
+	sl.traces = traces
+
+	return nil
+}
+
+func (sl *SARSALambda) Reset() {
+	sl.QLearning.Reset()
+	sl.traces.reset()
+}
+
+func (sl *SARSALambda) Learn(s1, a1 []float64, r float64, s2, a2 []float64) {
+	alpha := sl.alpha
+	gamma := sl.gamma
+
+	s1Idx := sl.state.index(s1)
+	s2Idx := sl.state.index(s2)
+
+	a1Idx := sl.actionsIndices[encodeFloat64Slice(a1)]
+	a2Idx := sl.actionsIndices[encodeFloat64Slice(a2)]
+
+	delta := r + gamma*sl.QTable[s2Idx][a2Idx] - sl.QTable[s1Idx][a1Idx]
+
+	sl.traces.visit(s1Idx, a1Idx, sl.actionSize)
+	sl.traces.update(sl.QTable, alpha*delta)
+	sl.traces.decay(gamma)
+}
+
+func (sl *SARSALambda) Save(dst string) error {
+	return sl.save(dst, sl.header())
+}
+
+func (sl *SARSALambda) Load(src string) error {
+	return sl.load(src, sl.header())
+}
+
+func (sl *SARSALambda) header() *checkpointHeader {
+	h := sl.QLearning.header("SARSA-Lambda")
+	sl.traces.describe(h)
+	return h
+}
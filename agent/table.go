@@ -0,0 +1,393 @@
+package agent
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// TableAgent is an Agent whose action values are a table over discretized
+// states. It can be exported to and imported from other formats.
+type TableAgent interface {
+	Agent
+	Table() [][]float64
+	SetTable(table [][]float64) error
+	StateNumber() []int
+	StateEdges() [][]float64
+	Actions() [][]float64
+}
+
+func (ql *QLearning) Table() [][]float64 {
+	return ql.QTable
+}
+
+func (ql *QLearning) SetTable(table [][]float64) error {
+	if err := checkTableShape(table, ql.stateSize, ql.actionSize); err != nil {
+		return fmt.Errorf("cannot set qtable: %w", err)
+	}
+	ql.QTable = table
+	return nil
+}
+
+func (ql *QLearning) StateNumber() []int {
+	return ql.state.number
+}
+
+func (ql *QLearning) StateEdges() [][]float64 {
+	return ql.state.binEdges()
+}
+
+// Table returns the mean of both tables.
+func (dq *DoubleQLearning) Table() [][]float64 {
+	res := make([][]float64, dq.stateSize)
+	for s := range res {
+		res[s] = dq.values(s)
+		for a := range res[s] {
+			res[s][a] /= 2.
+		}
+	}
+	return res
+}
+
+// SetTable sets table to both tables.
+func (dq *DoubleQLearning) SetTable(table [][]float64) error {
+	if err := checkTableShape(table, dq.stateSize, dq.actionSize); err != nil {
+		return fmt.Errorf("cannot set qtable: %w", err)
+	}
+	dq.QTable = table
+	dq.QTable2 = copyFloat64Matrix(table)
+	return nil
+}
+
+func checkTableShape(table [][]float64, stateSize, actionSize int) error {
+	if len(table) != stateSize {
+		return fmt.Errorf("state size must be %d, but %d", stateSize, len(table))
+	}
+	for s, row := range table {
+		if len(row) != actionSize {
+			return fmt.Errorf("action size of state %d must be %d, but %d", s, actionSize, len(row))
+		}
+	}
+	return nil
+}
+
+// Table formats
+const (
+	TableCSV  = "csv"
+	TableJSON = "json"
+	TableNPY  = "npy"
+)
+
+// ExportTable writes the table of ta to w in format.
+func ExportTable(w io.Writer, ta TableAgent, format string) error {
+	var err error
+	switch format {
+	case TableCSV:
+		err = exportCSV(w, ta)
+	case TableJSON:
+		err = exportJSON(w, ta)
+	case TableNPY:
+		err = exportNPY(w, ta.Table())
+	default:
+		err = fmt.Errorf("invalid format: %s", format)
+	}
+	if err != nil {
+		return fmt.Errorf("cannot export table: %w", err)
+	}
+	return nil
+}
+
+// ImportTable reads a table in format from r and sets it to ta. The actions
+// and the state layout in CSV and JSON must be those of ta, while NPY has only
+// the shape to check.
+func ImportTable(r io.Reader, ta TableAgent, format string) error {
+	var table [][]float64
+	var err error
+	switch format {
+	case TableCSV:
+		table, err = importCSV(r, ta)
+	case TableJSON:
+		table, err = importJSON(r, ta)
+	case TableNPY:
+		table, err = importNPY(r, ta)
+	default:
+		err = fmt.Errorf("invalid format: %s", format)
+	}
+	if err != nil {
+		return fmt.Errorf("cannot import table: %w", err)
+	}
+
+	if err := ta.SetTable(table); err != nil {
+		return fmt.Errorf("cannot import table: %w", err)
+	}
+	return nil
+}
+
+// exportCSV writes a row per state: the state index, the bin index of every
+// dimension and the action values.
+func exportCSV(w io.Writer, ta TableAgent) error {
+	number := ta.StateNumber()
+
+	cw := csv.NewWriter(w)
+	if err := cw.Write(csvHeader(ta)); err != nil {
+		return err
+	}
+
+	for s, row := range ta.Table() {
+		record := []string{strconv.Itoa(s)}
+		for _, bin := range binIndices(s, number) {
+			record = append(record, strconv.Itoa(bin))
+		}
+		for _, v := range row {
+			record = append(record, strconv.FormatFloat(v, 'g', -1, 64))
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+// csvHeader names the columns of a row of exportCSV.
+func csvHeader(ta TableAgent) []string {
+	res := []string{"state"}
+	for d := range ta.StateNumber() {
+		res = append(res, fmt.Sprintf("bin%d", d))
+	}
+	for _, a := range ta.Actions() {
+		res = append(res, "a="+encodeFloat64Slice(a))
+	}
+	return res
+}
+
+// binIndices is the inverse of discretizer.index.
+func binIndices(s int, number []int) []int {
+	res := make([]int, len(number))
+	for d := len(number) - 1; d >= 0; d-- {
+		res[d] = s % number[d]
+		s /= number[d]
+	}
+	return res
+}
+
+func importCSV(r io.Reader, ta TableAgent) ([][]float64, error) {
+	records, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) < 1 {
+		return nil, fmt.Errorf("empty csv")
+	}
+
+	number := ta.StateNumber()
+	actionSize := len(ta.Actions())
+
+	header := csvHeader(ta)
+	if !reflect.DeepEqual(records[0], header) {
+		return nil, fmt.Errorf("header must be %v, but %v", header, records[0])
+	}
+
+	res := make([][]float64, len(records)-1)
+	for i, record := range records[1:] {
+		s, err := strconv.Atoi(record[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid state at row %d: %w", i+1, err)
+		}
+		if s < 0 || s >= len(res) || res[s] != nil {
+			return nil, fmt.Errorf("invalid or duplicate state %d at row %d", s, i+1)
+		}
+
+		for d, bin := range binIndices(s, number) {
+			if record[1+d] != strconv.Itoa(bin) {
+				return nil, fmt.Errorf("bin%d of state %d must be %d, but %s at row %d",
+					d, s, bin, record[1+d], i+1)
+			}
+		}
+
+		row := make([]float64, actionSize)
+		for a, str := range record[len(record)-actionSize:] {
+			row[a], err = strconv.ParseFloat(str, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid value at row %d: %w", i+1, err)
+			}
+		}
+		res[s] = row
+	}
+
+	return res, nil
+}
+
+type tableJSON struct {
+	StateNumber []int       `json:"state_number"`
+	BinEdges    [][]float64 `json:"bin_edges"`
+	Actions     [][]float64 `json:"actions"`
+	QTable      [][]float64 `json:"qtable"`
+}
+
+func exportJSON(w io.Writer, ta TableAgent) error {
+	data := tableJSON{ta.StateNumber(), ta.StateEdges(), ta.Actions(), ta.Table()}
+	return json.NewEncoder(w).Encode(&data)
+}
+
+func importJSON(r io.Reader, ta TableAgent) ([][]float64, error) {
+	var data tableJSON
+	if err := json.NewDecoder(r).Decode(&data); err != nil {
+		return nil, err
+	}
+
+	if !reflect.DeepEqual(data.Actions, ta.Actions()) {
+		return nil, fmt.Errorf("actions must be %v, but %v", ta.Actions(), data.Actions)
+	}
+	if !reflect.DeepEqual(data.StateNumber, ta.StateNumber()) {
+		return nil, fmt.Errorf("state_number must be %v, but %v", ta.StateNumber(), data.StateNumber)
+	}
+	if !reflect.DeepEqual(data.BinEdges, ta.StateEdges()) {
+		return nil, fmt.Errorf("bin_edges must be %v, but %v", ta.StateEdges(), data.BinEdges)
+	}
+
+	return data.QTable, nil
+}
+
+const npyMagic = "\x93NUMPY"
+
+// exportNPY writes table as a NumPy .npy version 1.0 float64 array of shape
+// (states, actions).
+func exportNPY(w io.Writer, table [][]float64) error {
+	cols := 0
+	if len(table) > 0 {
+		cols = len(table[0])
+	}
+
+	header := fmt.Sprintf("{'descr': '<f8', 'fortran_order': False, 'shape': (%d, %d), }",
+		len(table), cols)
+	// magic(6) + version(2) + header len(2) + header + '\n' is aligned to 64.
+	pad := 64 - (10+len(header)+1)%64
+	if pad == 64 {
+		pad = 0
+	}
+	header += strings.Repeat(" ", pad) + "\n"
+
+	bw := bufio.NewWriter(w)
+	bw.WriteString(npyMagic)
+	bw.Write([]byte{1, 0})
+	binary.Write(bw, binary.LittleEndian, uint16(len(header)))
+	bw.WriteString(header)
+
+	buf := make([]byte, 8)
+	for _, row := range table {
+		for _, v := range row {
+			binary.LittleEndian.PutUint64(buf, math.Float64bits(v))
+			bw.Write(buf)
+		}
+	}
+
+	return bw.Flush()
+}
+
+var npyShapeRegexp = regexp.MustCompile(`'shape':\s*\((\d+),\s*(\d+)\s*,?\s*\)`)
+
+// importNPY reads a table of the shape of ta. The shape in the header is
+// checked before anything is allocated for it, and if r can seek, so is the
+// number of bytes left.
+func importNPY(r io.Reader, ta TableAgent) ([][]float64, error) {
+	br := bufio.NewReader(r)
+
+	pre := make([]byte, 8)
+	if _, err := io.ReadFull(br, pre); err != nil {
+		return nil, err
+	}
+	if string(pre[:6]) != npyMagic {
+		return nil, fmt.Errorf("not a npy file")
+	}
+
+	var headerLen int
+	switch pre[6] {
+	case 1:
+		var n uint16
+		if err := binary.Read(br, binary.LittleEndian, &n); err != nil {
+			return nil, err
+		}
+		headerLen = int(n)
+	case 2, 3:
+		var n uint32
+		if err := binary.Read(br, binary.LittleEndian, &n); err != nil {
+			return nil, err
+		}
+		headerLen = int(n)
+	default:
+		return nil, fmt.Errorf("unsupported npy version %d", pre[6])
+	}
+
+	header := make([]byte, headerLen)
+	if _, err := io.ReadFull(br, header); err != nil {
+		return nil, err
+	}
+	if !strings.Contains(string(header), "'<f8'") {
+		return nil, fmt.Errorf("dtype must be <f8: %s", header)
+	}
+	if strings.Contains(string(header), "'fortran_order': True") {
+		return nil, fmt.Errorf("fortran order is not supported")
+	}
+	m := npyShapeRegexp.FindStringSubmatch(string(header))
+	if m == nil {
+		return nil, fmt.Errorf("shape must be 2-dimensional: %s", header)
+	}
+	rows, _ := strconv.Atoi(m[1])
+	cols, _ := strconv.Atoi(m[2])
+
+	if expected := len(ta.Table()); rows != expected {
+		return nil, fmt.Errorf("rows must be %d, but %s", expected, m[1])
+	}
+	if expected := len(ta.Actions()); cols != expected {
+		return nil, fmt.Errorf("cols must be %d, but %s", expected, m[2])
+	}
+	if sk, ok := r.(io.Seeker); ok {
+		left, err := npyBytesLeft(sk, br)
+		if err != nil {
+			return nil, err
+		}
+		if expected := int64(rows) * int64(cols) * 8; left < expected {
+			return nil, fmt.Errorf("data must be %d bytes, but %d", expected, left)
+		}
+	}
+
+	res := make([][]float64, rows)
+	buf := make([]byte, 8)
+	for i := range res {
+		res[i] = make([]float64, cols)
+		for j := range res[i] {
+			if _, err := io.ReadFull(br, buf); err != nil {
+				return nil, err
+			}
+			res[i][j] = math.Float64frombits(binary.LittleEndian.Uint64(buf))
+		}
+	}
+
+	return res, nil
+}
+
+// npyBytesLeft returns the number of bytes of sk not yet read through br.
+func npyBytesLeft(sk io.Seeker, br *bufio.Reader) (int64, error) {
+	cur, err := sk.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+	end, err := sk.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := sk.Seek(cur, io.SeekStart); err != nil {
+		return 0, err
+	}
+	return end - cur + int64(br.Buffered()), nil
+}
@@ -0,0 +1,318 @@
+package agent
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	utils "github.com/high-moctane/lab_scup2020/utils"
+)
+
+// TileCoding is a linear Q-learning agent over tile-coded features.
+type TileCoding struct {
+	randomness
+
+	alpha, gamma, eps float64
+	eval              bool
+
+	stateThresh [][]float64 // [[min, max], [min, max], ...]
+	tileNumber  []int       // tiles per dimension in each tiling
+	tileWidth   []float64
+	tilingSize  int // number of tilings
+	tileSize    int // number of tiles in each tiling
+	offsets     [][]float64
+
+	actionSet
+
+	Weights  [][]float64 // [feature][action]
+	Episodes int
+}
+
+func (tc *TileCoding) Init() error {
+	tc.InitRand()
+
+	if err := tc.loadEnv(); err != nil {
+		return fmt.Errorf("cannot init tile coding: %w", err)
+	}
+
+	initQ, err := utils.GetEnvFloat64("SCUP_AGENT_INIT_QVALUE")
+	if err != nil {
+		return fmt.Errorf("cannot init tile coding: %w", err)
+	}
+
+	weights := make([][]float64, tc.tilingSize*tc.tileSize)
+	for i := range weights {
+		weights[i] = make([]float64, tc.actionSize)
+		for j := range weights[i] {
+			weights[i][j] = initQ / float64(tc.tilingSize)
+		}
+	}
+	tc.Weights = weights
+
+	tc.Episodes = 0
+
+	return nil
+}
+
+func (tc *TileCoding) Reset() {
+	if tc.eval {
+		return
+	}
+	tc.Episodes += 1
+	lg.Info("tile coding episode %d", tc.Episodes)
+}
+
+func (tc *TileCoding) Action(s []float64) []float64 {
+	var idx int
+	if !tc.eval && tc.Rand().Float64() < tc.eps {
+		idx = tc.Rand().Intn(tc.actionSize)
+	} else {
+		idx = argmax(tc.values(tc.features(s)))
+	}
+
+	return tc.actions[idx]
+}
+
+func (tc *TileCoding) SetEval(eval bool) {
+	tc.eval = eval
+}
+
+func (tc *TileCoding) Epsilon() (float64, bool) {
+	if tc.eval {
+		return 0., true
+	}
+	return tc.eps, true
+}
+
+func (tc *TileCoding) EpisodeCount() int {
+	return tc.Episodes
+}
+
+func (tc *TileCoding) Learn(s1, a1 []float64, r float64, s2, a2 []float64) {
+	alpha := tc.alpha / float64(tc.tilingSize)
+	gamma := tc.gamma
+
+	f1 := tc.features(s1)
+	q2 := tc.values(tc.features(s2))
+
+	a1Idx := tc.actionsIndices[encodeFloat64Slice(a1)]
+
+	q1 := 0.
+	for _, f := range f1 {
+		q1 += tc.Weights[f][a1Idx]
+	}
+
+	delta := r + gamma*q2[argmax(q2)] - q1
+
+	for _, f := range f1 {
+		tc.Weights[f][a1Idx] += alpha * delta
+	}
+}
+
+func (tc *TileCoding) Save(dst string) error {
+	return saveCheckpoint(dst, tc.header(), tc)
+}
+
+func (tc *TileCoding) Load(src string) error {
+	var data TileCoding
+	if err := loadCheckpoint(src, tc.header(), &data); err != nil {
+		return err
+	}
+
+	tc.Weights = data.Weights
+	tc.Episodes = data.Episodes
+
+	return nil
+}
+
+func (tc *TileCoding) header() *checkpointHeader {
+	h := newCheckpointHeader("Tile-Coding")
+	h.StateThresh = tc.stateThresh
+	h.Actions = tc.actions
+	h.Shape["tile number"] = fmt.Sprint(tc.tileNumber)
+	h.Shape["tiling number"] = fmt.Sprint(tc.tilingSize)
+	h.Shape["tiling offset"] = fmt.Sprint(tc.offsets)
+	h.Params["alpha"] = fmt.Sprint(tc.alpha)
+	h.Params["gamma"] = fmt.Sprint(tc.gamma)
+	h.Params["epsilon"] = fmt.Sprint(tc.eps)
+	h.Episodes = tc.Episodes
+	return h
+}
+
+// features returns the active tile index of each tiling.
+func (tc *TileCoding) features(s []float64) []int {
+	res := make([]int, tc.tilingSize)
+
+	for t := 0; t < tc.tilingSize; t++ {
+		idx := 0
+		for d, v := range s {
+			minThresh := tc.stateThresh[d][0]
+			maxThresh := tc.stateThresh[d][1]
+			v = math.Max(minThresh, math.Min(maxThresh, v))
+
+			// Each tiling has one extra tile to cover its offset.
+			tile := int((v - minThresh + tc.offsets[t][d]) / tc.tileWidth[d])
+			if tile > tc.tileNumber[d] {
+				tile = tc.tileNumber[d]
+			}
+
+			idx = idx*(tc.tileNumber[d]+1) + tile
+		}
+		res[t] = t*tc.tileSize + idx
+	}
+
+	return res
+}
+
+func (tc *TileCoding) values(features []int) []float64 {
+	res := make([]float64, tc.actionSize)
+	for _, f := range features {
+		for a, w := range tc.Weights[f] {
+			res[a] += w
+		}
+	}
+	return res
+}
+
+func (tc *TileCoding) loadEnv() error {
+	if err := tc.loadParamsEnv(); err != nil {
+		return fmt.Errorf("cannot load env: %w", err)
+	}
+	if err := tc.loadTilingEnv(); err != nil {
+		return fmt.Errorf("cannot load env: %w", err)
+	}
+	if err := tc.loadActionEnv(); err != nil {
+		return fmt.Errorf("cannot load env: %w", err)
+	}
+	return nil
+}
+
+func (tc *TileCoding) loadParamsEnv() error {
+	alpha, err := utils.GetEnvFloat64("SCUP_AGENT_ALPHA")
+	if err != nil {
+		return fmt.Errorf("cannot load params env: %w", err)
+	}
+
+	gamma, err := utils.GetEnvFloat64("SCUP_AGENT_GAMMA")
+	if err != nil {
+		return fmt.Errorf("cannot load params env: %w", err)
+	}
+
+	eps, err := utils.GetEnvFloat64("SCUP_AGENT_EPSILON")
+	if err != nil {
+		return fmt.Errorf("cannot load params env: %w", err)
+	}
+
+	tc.alpha = alpha
+	tc.gamma = gamma
+	tc.eps = eps
+
+	return nil
+}
+
+func (tc *TileCoding) loadTilingEnv() error {
+	stateThresh, err := parseStateThresh()
+	if err != nil {
+		return fmt.Errorf("cannot load tiling env: %w", err)
+	}
+
+	tileNumber, err := parseTileNumber()
+	if err != nil {
+		return fmt.Errorf("cannot load tiling env: %w", err)
+	}
+
+	if len(stateThresh) != len(tileNumber) {
+		return fmt.Errorf("len(stateThresh) == %v, but len(tileNumber) == %v",
+			len(stateThresh), len(tileNumber))
+	}
+
+	tilingSize, err := utils.GetEnvInt("SCUP_AGENT_TILING_NUMBER")
+	if err != nil {
+		return fmt.Errorf("cannot load tiling env: %w", err)
+	}
+	if tilingSize < 1 {
+		return fmt.Errorf("invalid tiling number: %d", tilingSize)
+	}
+
+	displacement, err := parseTilingOffset(len(tileNumber))
+	if err != nil {
+		return fmt.Errorf("cannot load tiling env: %w", err)
+	}
+
+	tileWidth := make([]float64, len(tileNumber))
+	tileSize := 1
+	for d, n := range tileNumber {
+		tileWidth[d] = (stateThresh[d][1] - stateThresh[d][0]) / float64(n)
+		tileSize *= n + 1
+	}
+
+	offsets := make([][]float64, tilingSize)
+	for t := range offsets {
+		offsets[t] = make([]float64, len(tileNumber))
+		for d := range offsets[t] {
+			offsets[t][d] = math.Mod(float64(t)*displacement[d], float64(tilingSize)) /
+				float64(tilingSize) * tileWidth[d]
+		}
+	}
+
+	tc.stateThresh = stateThresh
+	tc.tileNumber = tileNumber
+	tc.tileWidth = tileWidth
+	tc.tilingSize = tilingSize
+	tc.tileSize = tileSize
+	tc.offsets = offsets
+
+	return nil
+}
+
+func parseTileNumber() ([]int, error) {
+	str, ok := os.LookupEnv("SCUP_AGENT_TILE_NUMBER")
+	if !ok {
+		return nil, fmt.Errorf("cannot find SCUP_AGENT_TILE_NUMBER")
+	}
+
+	res := []int{}
+
+	for _, elem := range strings.Split(str, ":") {
+		v, err := strconv.Atoi(elem)
+		if err != nil {
+			return nil, fmt.Errorf("invalid tile number value: %w", err)
+		}
+		if v < 1 {
+			return nil, fmt.Errorf("invalid tile number value: %d", v)
+		}
+
+		res = append(res, v)
+	}
+
+	return res, nil
+}
+
+// parseTilingOffset returns the displacement vector of the tilings in units of
+// tileWidth / tilingNumber. "uniform" shifts every dimension equally,
+// "asymmetric" uses the odd numbers 1, 3, 5, ... to avoid diagonal artifacts.
+func parseTilingOffset(dim int) ([]float64, error) {
+	str, ok := os.LookupEnv("SCUP_AGENT_TILING_OFFSET")
+	if !ok {
+		return nil, fmt.Errorf("cannot find SCUP_AGENT_TILING_OFFSET")
+	}
+
+	res := make([]float64, dim)
+
+	switch str {
+	case "uniform":
+		for d := range res {
+			res[d] = 1.
+		}
+	case "asymmetric":
+		for d := range res {
+			res[d] = float64(2*d + 1)
+		}
+	default:
+		return nil, fmt.Errorf("invalid tiling offset: %s", str)
+	}
+
+	return res, nil
+}
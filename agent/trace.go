@@ -0,0 +1,99 @@
+package agent
+
+import (
+	"fmt"
+	"os"
+
+	utils "github.com/high-moctane/lab_scup2020/utils"
+)
+
+// traceMinValue is the magnitude below which a trace is dropped.
+const traceMinValue = 1e-4
+
+type traceKey struct {
+	s, a int
+}
+
+// eligibilityTraces is a sparse eligibility trace over a QTable.
+type eligibilityTraces struct {
+	lambda     float64
+	accumulate bool
+	e          map[traceKey]float64
+}
+
+func newEligibilityTraces() (*eligibilityTraces, error) {
+	lambda, err := utils.GetEnvFloat64("SCUP_AGENT_LAMBDA")
+	if err != nil {
+		return nil, fmt.Errorf("cannot make eligibility traces: %w", err)
+	}
+
+	kind, ok := os.LookupEnv("SCUP_AGENT_TRACE")
+	if !ok {
+		return nil, fmt.Errorf("cannot find SCUP_AGENT_TRACE")
+	}
+
+	var accumulate bool
+	switch kind {
+	case "replacing":
+		accumulate = false
+	case "accumulating":
+		accumulate = true
+	default:
+		return nil, fmt.Errorf("invalid trace kind: %s", kind)
+	}
+
+	res := &eligibilityTraces{
+		lambda:     lambda,
+		accumulate: accumulate,
+		e:          map[traceKey]float64{},
+	}
+
+	return res, nil
+}
+
+func (et *eligibilityTraces) describe(h *checkpointHeader) {
+	h.Params["lambda"] = fmt.Sprint(et.lambda)
+	if et.accumulate {
+		h.Params["trace"] = "accumulating"
+	} else {
+		h.Params["trace"] = "replacing"
+	}
+}
+
+func (et *eligibilityTraces) reset() {
+	et.e = map[traceKey]float64{}
+}
+
+// visit marks (sIdx, aIdx) as visited. Replacing traces also clear the other
+// actions of the same state.
+func (et *eligibilityTraces) visit(sIdx, aIdx, actionSize int) {
+	if et.accumulate {
+		et.e[traceKey{sIdx, aIdx}] += 1.
+		return
+	}
+
+	for i := 0; i < actionSize; i++ {
+		delete(et.e, traceKey{sIdx, i})
+	}
+	et.e[traceKey{sIdx, aIdx}] = 1.
+}
+
+// update adds step * e(s, a) to every traced cell of qtable.
+func (et *eligibilityTraces) update(qtable [][]float64, step float64) {
+	for k, e := range et.e {
+		qtable[k.s][k.a] += step * e
+	}
+}
+
+// decay multiplies every trace by gamma * lambda.
+func (et *eligibilityTraces) decay(gamma float64) {
+	factor := gamma * et.lambda
+	for k, e := range et.e {
+		e *= factor
+		if e < traceMinValue {
+			delete(et.e, k)
+			continue
+		}
+		et.e[k] = e
+	}
+}
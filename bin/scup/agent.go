@@ -0,0 +1,113 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/high-moctane/lab_scup2020/agent"
+	"github.com/high-moctane/lab_scup2020/logger"
+	"github.com/joho/godotenv"
+)
+
+const agentUsage = `usage:
+	scup agent export <env file> <agent data> <output.{csv,json,npy}>
+	scup agent import <env file> <input.{csv,json,npy}> <agent data>`
+
+// runAgent runs the agent subcommand which converts the table of a tabular
+// agent between its checkpoint and CSV, JSON or NumPy .npy.
+func runAgent(args []string) error {
+	if len(args) != 4 {
+		return fmt.Errorf("invalid args\n%s", agentUsage)
+	}
+
+	if err := godotenv.Load(args[1]); err != nil {
+		return fmt.Errorf("dotenv failed: %w", err)
+	}
+
+	switch args[0] {
+	case "export":
+		return exportAgent(args[2], args[3])
+	case "import":
+		return importAgent(args[2], args[3])
+	default:
+		return fmt.Errorf("invalid agent command: %s\n%s", args[0], agentUsage)
+	}
+}
+
+func exportAgent(src, dst string) error {
+	ta, err := newTableAgent()
+	if err != nil {
+		return fmt.Errorf("export error: %w", err)
+	}
+	if err := ta.Load(src); err != nil {
+		return fmt.Errorf("export error: %w", err)
+	}
+
+	f, err := os.Create(dst)
+	if err != nil {
+		return fmt.Errorf("export error: %w", err)
+	}
+
+	if err := agent.ExportTable(f, ta, tableFormat(dst)); err != nil {
+		f.Close()
+		return fmt.Errorf("export error: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("export error: %w", err)
+	}
+
+	logger.Get().Info("table exported to %s", dst)
+	return nil
+}
+
+// importAgent sets the table in src to the agent at dst. The other agent data
+// such as episodes is kept if dst exists.
+func importAgent(src, dst string) error {
+	ta, err := newTableAgent()
+	if err != nil {
+		return fmt.Errorf("import error: %w", err)
+	}
+
+	agentDataNotFoundError := &agent.AgentDataNotFound{}
+	if err := ta.Load(dst); err != nil && !errors.As(err, &agentDataNotFoundError) {
+		return fmt.Errorf("import error: %w", err)
+	}
+
+	f, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("import error: %w", err)
+	}
+	defer f.Close()
+
+	if err := agent.ImportTable(f, ta, tableFormat(src)); err != nil {
+		return fmt.Errorf("import error: %w", err)
+	}
+
+	if err := ta.Save(dst); err != nil {
+		return fmt.Errorf("import error: %w", err)
+	}
+
+	return nil
+}
+
+func newTableAgent() (agent.TableAgent, error) {
+	ag, err := agent.SelectAgent()
+	if err != nil {
+		return nil, err
+	}
+	ta, ok := ag.(agent.TableAgent)
+	if !ok {
+		return nil, fmt.Errorf("agent %T has no table", ag)
+	}
+	if err := ta.Init(); err != nil {
+		return nil, err
+	}
+	return ta, nil
+}
+
+func tableFormat(path string) string {
+	return strings.TrimPrefix(strings.ToLower(filepath.Ext(path)), ".")
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/high-moctane/lab_scup2020/dashboard"
+	"github.com/high-moctane/lab_scup2020/logger"
+	"github.com/high-moctane/lab_scup2020/metrics"
+	"github.com/high-moctane/lab_scup2020/utils"
+)
+
+// newServeMux returns the handlers served at SCUP_HTTP_ADDR: the dashboard
+// of hub at / and the metrics at /metrics.
+func newServeMux(hub *dashboard.Hub) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.Handle("/", hub)
+	mux.Handle("/metrics", metrics.Handler())
+	return mux
+}
+
+// newDashboardHub makes a dashboard hub from the env:
+//
+//	SCUP_DASHBOARD_INTERVAL  minimum milliseconds between steps sent (default 100)
+//	SCUP_DASHBOARD_HISTORY   episodes replayed to a new client (default 10000)
+func newDashboardHub() (*dashboard.Hub, error) {
+	interval, err := utils.GetEnvIntDefault("SCUP_DASHBOARD_INTERVAL", 100)
+	if err != nil {
+		return nil, err
+	}
+	history, err := utils.GetEnvIntDefault("SCUP_DASHBOARD_HISTORY", 10000)
+	if err != nil {
+		return nil, err
+	}
+	return dashboard.NewHub(time.Duration(interval)*time.Millisecond, history), nil
+}
+
+// httpAddr returns addr, or addr on localhost if it has no host, so that the
+// dashboard is not open to the network unless a host such as 0.0.0.0 is
+// given.
+func httpAddr(addr string) (string, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", fmt.Errorf("invalid http addr %s: %w", addr, err)
+	}
+	if host == "" {
+		host = "127.0.0.1"
+	}
+	return net.JoinHostPort(host, port), nil
+}
+
+// serveHTTP serves h at addr until ctx is done. An addr without a host is on
+// localhost.
+func serveHTTP(ctx context.Context, addr string, h http.Handler) error {
+	addr, err := httpAddr(addr)
+	if err != nil {
+		return err
+	}
+
+	// Requests are cancelled with ctx so that event streams end on shutdown.
+	srv := &http.Server{
+		Addr:        addr,
+		Handler:     h,
+		BaseContext: func(net.Listener) context.Context { return ctx },
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- srv.ListenAndServe()
+	}()
+	logger.Get().Component("http").Info("serving on %s", addr)
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		return err
+	}
+	if err := <-errCh; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
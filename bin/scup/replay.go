@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	scup "github.com/high-moctane/lab_scup2020"
+	"github.com/high-moctane/lab_scup2020/agent"
+	"github.com/high-moctane/lab_scup2020/logger"
+	"github.com/high-moctane/lab_scup2020/recording"
+	"github.com/joho/godotenv"
+)
+
+const replayUsage = `usage:
+	scup replay learn <env file> <recording>...
+	scup replay render <recording>...`
+
+// runReplay runs the replay subcommand which feeds recorded episodes to the
+// agents offline or prints them.
+func runReplay(args []string) error {
+	if len(args) < 2 {
+		return fmt.Errorf("invalid args\n%s", replayUsage)
+	}
+
+	switch args[0] {
+	case "learn":
+		if len(args) < 3 {
+			return fmt.Errorf("invalid args\n%s", replayUsage)
+		}
+		if err := godotenv.Load(args[1]); err != nil {
+			return fmt.Errorf("dotenv failed: %w", err)
+		}
+		return replayLearn(args[2:])
+	case "render":
+		return replayRender(os.Stdout, args[1:])
+	default:
+		return fmt.Errorf("invalid replay command: %s\n%s", args[0], replayUsage)
+	}
+}
+
+// replayLearn makes the up and down agents learn the recordings and saves
+// them to their data paths. The recordings must be of SCUP_ENV_NAME.
+func replayLearn(paths []string) error {
+	envName := os.Getenv("SCUP_ENV_NAME")
+
+	dataPaths := map[string]string{
+		"up":   os.Getenv("SCUP_RL_AGENT_UP_DATA_PATH"),
+		"down": os.Getenv("SCUP_RL_AGENT_DOWN_DATA_PATH"),
+	}
+	agents := map[string]agent.Agent{}
+
+	for _, path := range paths {
+		ep, err := recording.Load(path)
+		if err != nil {
+			return fmt.Errorf("replay error: %w", err)
+		}
+		if ep.Env != envName {
+			return fmt.Errorf("replay error: %s is of env %q, but SCUP_ENV_NAME is %q", path, ep.Env, envName)
+		}
+
+		ag, ok := agents[ep.Agent]
+		if !ok {
+			dataPath, ok := dataPaths[ep.Agent]
+			if !ok || dataPath == "" {
+				return fmt.Errorf("replay error: no data path for agent %q of %s", ep.Agent, path)
+			}
+			ag, err = loadAgent(dataPath)
+			if err != nil {
+				return fmt.Errorf("replay error: %w", err)
+			}
+			agents[ep.Agent] = ag
+		}
+
+		returns, err := scup.ReplayLearn(ag, ep)
+		if err != nil {
+			return fmt.Errorf("replay error: %s: %w", path, err)
+		}
+		logger.Get().Component("replay").
+			With("agent", ep.Agent, "episode", ep.Episode, "steps", len(ep.Steps), "returns", returns).
+			Info("learned %s", path)
+	}
+
+	for name, ag := range agents {
+		if err := ag.Save(dataPaths[name]); err != nil {
+			return fmt.Errorf("replay error: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func loadAgent(path string) (agent.Agent, error) {
+	ag, err := agent.SelectAgent()
+	if err != nil {
+		return nil, err
+	}
+	if err := ag.Init(); err != nil {
+		return nil, err
+	}
+
+	agentDataNotFoundError := &agent.AgentDataNotFound{}
+	if err := ag.Load(path); err != nil && !errors.As(err, &agentDataNotFoundError) {
+		return nil, err
+	}
+	return ag, nil
+}
+
+// replayRender prints the recordings as tables.
+func replayRender(w io.Writer, paths []string) error {
+	for _, path := range paths {
+		ep, err := recording.Load(path)
+		if err != nil {
+			return fmt.Errorf("replay error: %w", err)
+		}
+
+		fmt.Fprintf(w, "# %s: env %s, agent %s, episode %d, %s, %d steps\n",
+			path, ep.Env, ep.Agent, ep.Episode, ep.Time.Format("2006-01-02 15:04:05"), len(ep.Steps))
+
+		tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(tw, "step\taction\tstate\treward\tfinish\traw\t")
+		fmt.Fprintf(tw, "0\t\t%s\t%s\t\t%s\t\n",
+			formatFloats(ep.InitialState), formatFloat(ep.InitialReward), hex.EncodeToString(ep.InitialRaw))
+		for i, step := range ep.Steps {
+			fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%v\t%s\t\n",
+				i+1, formatFloats(step.Action), formatFloats(step.State), formatFloat(step.Reward),
+				step.Finish, hex.EncodeToString(step.Raw))
+		}
+		if err := tw.Flush(); err != nil {
+			return fmt.Errorf("replay error: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func formatFloats(xs []float64) string {
+	strs := make([]string, len(xs))
+	for i, x := range xs {
+		strs[i] = formatFloat(x)
+	}
+	return strings.Join(strs, " ")
+}
+
+func formatFloat(x float64) string {
+	return strconv.FormatFloat(x, 'f', 4, 64)
+}
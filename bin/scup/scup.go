@@ -2,8 +2,8 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
-	"log"
 	"os"
 	"os/signal"
 	"sync"
@@ -11,16 +11,15 @@ import (
 	"time"
 
 	scup "github.com/high-moctane/lab_scup2020"
-	_ "github.com/high-moctane/lab_scup2020/logger"
+	environ "github.com/high-moctane/lab_scup2020/environment"
+	"github.com/high-moctane/lab_scup2020/logger"
 	"github.com/high-moctane/lab_scup2020/utils"
 	"github.com/joho/godotenv"
 )
 
 func main() {
 	if err := run(os.Args); err != nil {
-		// logger.Get().Fatal("%v", err)
-		log.Println(err)
-		os.Exit(1)
+		logger.Get().Fatal("%v", err)
 	}
 }
 
@@ -30,11 +29,28 @@ func run(args []string) error {
 	defer cancel()
 	wg := new(sync.WaitGroup)
 
-	if len(args) != 2 {
+	if len(args) > 1 && args[1] == "agent" {
+		return runAgent(args[2:])
+	}
+	if len(args) > 1 && args[1] == "replay" {
+		return runReplay(args[2:])
+	}
+
+	fs := flag.NewFlagSet(args[0], flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s [flags] <env file>\n", args[0])
+		fs.PrintDefaults()
+	}
+	environ.AddSerialFlags(fs)
+	if err := fs.Parse(args[1:]); err != nil {
+		return fmt.Errorf("invalid args: %w", err)
+	}
+
+	if fs.NArg() != 1 {
 		return fmt.Errorf("invalid args")
 	}
 
-	if err := godotenv.Load(args[1]); err != nil {
+	if err := godotenv.Load(fs.Arg(0)); err != nil {
 		return fmt.Errorf("dotenv failed: %w", err)
 	}
 
@@ -49,6 +65,26 @@ func run(args []string) error {
 		return fmt.Errorf("run error: %w", err)
 	}
 
+	// The dashboard and the metrics are off unless SCUP_HTTP_ADDR is set,
+	// e.g. to :8080 for localhost only or to 0.0.0.0:8080 for the network.
+	// They have no authentication.
+	if addr := utils.GetEnvStringDefault("SCUP_HTTP_ADDR", ""); addr != "" {
+		hub, err := newDashboardHub()
+		if err != nil {
+			return fmt.Errorf("run error: %w", err)
+		}
+		rl.AddObserver(hub)
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			if err := serveHTTP(ctx, addr, newServeMux(hub)); err != nil {
+				logger.Get().Error("http error: %v", err)
+			}
+		}()
+	}
+
 	time.Sleep(2 * time.Second)
 
 	wg.Add(1)
@@ -56,7 +92,7 @@ func run(args []string) error {
 		defer wg.Done()
 
 		if err := rl.Run(ctx, mode); err != nil {
-			log.Println(fmt.Errorf("run error: %w", err))
+			logger.Get().Error("run error: %v", err)
 			return
 		}
 	}()
@@ -71,8 +107,9 @@ func run(args []string) error {
 	)
 
 	<-sig
+	cancel()
 	wg.Wait()
-	log.Println("Interrupted")
+	logger.Get().Info("Interrupted")
 
 	return nil
 }
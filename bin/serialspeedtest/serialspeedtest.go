@@ -1,22 +1,28 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"log"
 	"os"
 	"time"
 
 	environ "github.com/high-moctane/lab_scup2020/environment"
-	"github.com/tarm/serial"
+	"github.com/joho/godotenv"
 )
 
 func main() {
-	c := &serial.Config{
-		Name: "/dev/ttyAMA0",
-		Baud: 57600,
+	envFile := flag.String("env", "", "env file with SCUP_SERIAL_* settings")
+	environ.AddSerialFlags(flag.CommandLine)
+	flag.Parse()
+
+	if *envFile != "" {
+		if err := godotenv.Load(*envFile); err != nil {
+			log.Fatal(err)
+		}
 	}
 
-	s, err := serial.OpenPort(c)
+	s, err := environ.OpenSerial()
 	if err != nil {
 		log.Fatal(err)
 	}
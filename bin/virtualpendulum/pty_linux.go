@@ -0,0 +1,64 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// openPTY opens a pseudo terminal in raw mode and returns its master and the
+// path of its slave.
+func openPTY() (*os.File, string, error) {
+	f, err := os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var unlock int32
+	if err := ioctl(f, syscall.TIOCSPTLCK, uintptr(unsafe.Pointer(&unlock))); err != nil {
+		f.Close()
+		return nil, "", fmt.Errorf("cannot unlock pty: %w", err)
+	}
+
+	var n uint32
+	if err := ioctl(f, syscall.TIOCGPTN, uintptr(unsafe.Pointer(&n))); err != nil {
+		f.Close()
+		return nil, "", fmt.Errorf("cannot get pty number: %w", err)
+	}
+
+	// Raw mode so that the frames are not translated or echoed.
+	var t syscall.Termios
+	if err := ioctl(f, syscall.TCGETS, uintptr(unsafe.Pointer(&t))); err != nil {
+		f.Close()
+		return nil, "", fmt.Errorf("cannot get termios: %w", err)
+	}
+	t.Iflag &^= syscall.IGNBRK | syscall.BRKINT | syscall.PARMRK | syscall.ISTRIP |
+		syscall.INLCR | syscall.IGNCR | syscall.ICRNL | syscall.IXON
+	t.Oflag &^= syscall.OPOST
+	t.Lflag &^= syscall.ECHO | syscall.ECHONL | syscall.ICANON | syscall.ISIG | syscall.IEXTEN
+	t.Cflag &^= syscall.CSIZE | syscall.PARENB
+	t.Cflag |= syscall.CS8
+	if err := ioctl(f, syscall.TCSETS, uintptr(unsafe.Pointer(&t))); err != nil {
+		f.Close()
+		return nil, "", fmt.Errorf("cannot set termios: %w", err)
+	}
+
+	return f, fmt.Sprintf("/dev/pts/%d", n), nil
+}
+
+func ioctl(f *os.File, req, arg uintptr) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), req, arg)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+func isEIO(err error) bool {
+	return errors.Is(err, syscall.EIO)
+}
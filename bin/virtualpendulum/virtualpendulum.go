@@ -0,0 +1,65 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	environ "github.com/high-moctane/lab_scup2020/environment"
+)
+
+// virtualpendulum serves a VirtualPendulum on a pseudo terminal so that the
+// serial tools and scup can run against it instead of the rig.
+func main() {
+	step := flag.Duration("step", 0, "simulated time per request (0 follows the wall clock)")
+	link := flag.String("link", "", "symlink to make to the pty, e.g. /tmp/ttyRRP")
+	flag.Parse()
+
+	pty, name, err := openPTY()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer pty.Close()
+
+	if *link != "" {
+		os.Remove(*link)
+		if err := os.Symlink(name, *link); err != nil {
+			log.Fatal(err)
+		}
+		defer os.Remove(*link)
+		name = *link
+	}
+
+	fmt.Println(name)
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGTERM, syscall.SIGINT)
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- serve(pty, *step)
+	}()
+
+	select {
+	case <-sig:
+	case err := <-errCh:
+		log.Println(err)
+	}
+}
+
+// serve serves a new pendulum on pty. A pty reads EIO while no side is
+// open, so it waits for the next open.
+func serve(pty *os.File, step time.Duration) error {
+	vp := environ.NewVirtualPendulum(step)
+	for {
+		err := vp.Serve(pty)
+		if err == nil || !isEIO(err) {
+			return err
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
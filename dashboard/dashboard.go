@@ -0,0 +1,144 @@
+package dashboard
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/high-moctane/lab_scup2020/telemetry"
+)
+
+// Hub streams steps and episodes of RL to dashboard clients by server-sent
+// events. Steps are sent at most once per interval; episodes are all sent and
+// the last ones are replayed to new clients so that they can draw the return
+// curves from the start.
+type Hub struct {
+	mu       sync.Mutex
+	clients  map[chan []byte]struct{}
+	history  [][]byte
+	maxHist  int
+	interval time.Duration
+	lastStep time.Time
+}
+
+// clientBuffer is the number of events queued for a client. Events to a
+// client whose queue is full are dropped.
+const clientBuffer = 256
+
+func NewHub(interval time.Duration, maxHistory int) *Hub {
+	return &Hub{
+		clients:  map[chan []byte]struct{}{},
+		maxHist:  maxHistory,
+		interval: interval,
+	}
+}
+
+// OnStep sends rec if interval has passed since the last step sent.
+func (h *Hub) OnStep(rec *telemetry.StepRecord) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if len(h.clients) == 0 || rec.Time.Sub(h.lastStep) < h.interval {
+		return
+	}
+	h.lastStep = rec.Time
+
+	if ev, err := encodeEvent("step", rec); err == nil {
+		h.broadcast(ev)
+	}
+}
+
+// OnEpisode sends rec and keeps it for new clients.
+func (h *Hub) OnEpisode(rec *telemetry.EpisodeRecord) {
+	ev, err := encodeEvent("episode", rec)
+	if err != nil {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.history = append(h.history, ev)
+	if len(h.history) > h.maxHist {
+		h.history = h.history[len(h.history)-h.maxHist:]
+	}
+	h.broadcast(ev)
+}
+
+func (h *Hub) broadcast(ev []byte) {
+	for ch := range h.clients {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
+
+func encodeEvent(name string, v interface{}) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(fmt.Sprintf("event: %s\ndata: %s\n\n", name, data)), nil
+}
+
+// ServeHTTP serves the dashboard page at / and the event stream at /events.
+func (h *Hub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/":
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		io.WriteString(w, indexHTML)
+	case "/events":
+		h.serveEvents(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (h *Hub) serveEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	ch := make(chan []byte, clientBuffer)
+
+	// OnEpisode only appends past the end of this slice or trims its head,
+	// so the events can be written without the lock.
+	h.mu.Lock()
+	history := h.history
+	h.clients[ch] = struct{}{}
+	h.mu.Unlock()
+
+	defer func() {
+		h.mu.Lock()
+		delete(h.clients, ch)
+		h.mu.Unlock()
+	}()
+
+	for _, ev := range history {
+		if _, err := w.Write(ev); err != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case ev := <-ch:
+			if _, err := w.Write(ev); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
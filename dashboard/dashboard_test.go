@@ -0,0 +1,97 @@
+package dashboard
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/high-moctane/lab_scup2020/telemetry"
+)
+
+func readEvent(t *testing.T, sc *bufio.Scanner) (name, data string) {
+	t.Helper()
+
+	for sc.Scan() {
+		line := sc.Text()
+		switch {
+		case strings.HasPrefix(line, "event: "):
+			name = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			data = strings.TrimPrefix(line, "data: ")
+		case line == "":
+			return
+		}
+	}
+	t.Fatalf("stream ended: %v", sc.Err())
+	return
+}
+
+func TestHubEvents(t *testing.T) {
+	hub := NewHub(time.Second, 2)
+	for i := 0; i < 3; i++ {
+		hub.OnEpisode(&telemetry.EpisodeRecord{Agent: "up", Episode: i})
+	}
+
+	srv := httptest.NewServer(hub)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("unexpected content type %s", ct)
+	}
+
+	sc := bufio.NewScanner(resp.Body)
+
+	// Only the last 2 episodes are replayed.
+	for _, episode := range []string{`"episode":1`, `"episode":2`} {
+		if name, data := readEvent(t, sc); name != "episode" || !strings.Contains(data, episode) {
+			t.Errorf("expected episode event with %s, but %s %s", episode, name, data)
+		}
+	}
+
+	// Wait for the client to be registered.
+	for {
+		hub.mu.Lock()
+		n := len(hub.clients)
+		hub.mu.Unlock()
+		if n > 0 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	now := time.Now()
+	hub.OnStep(&telemetry.StepRecord{Time: now, Agent: "up", Step: 1, State: []float64{0.5}, Action: []float64{1}})
+	// Within the interval
+	hub.OnStep(&telemetry.StepRecord{Time: now.Add(time.Millisecond), Agent: "up", Step: 2})
+	hub.OnEpisode(&telemetry.EpisodeRecord{Agent: "down", Episode: 3})
+
+	if name, data := readEvent(t, sc); name != "step" || !strings.Contains(data, `"step":1`) {
+		t.Errorf("expected step 1, but %s %s", name, data)
+	}
+	if name, data := readEvent(t, sc); name != "episode" || !strings.Contains(data, `"agent":"down"`) {
+		t.Errorf("expected down episode, but %s %s", name, data)
+	}
+}
+
+func TestHubIndex(t *testing.T) {
+	rec := httptest.NewRecorder()
+	NewHub(0, 0).ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+
+	if !strings.Contains(rec.Body.String(), `new EventSource("events")`) {
+		t.Error("index does not subscribe to events")
+	}
+
+	rec = httptest.NewRecorder()
+	NewHub(0, 0).ServeHTTP(rec, httptest.NewRequest("GET", "/unknown", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("expected 404, but %d", rec.Code)
+	}
+}
@@ -0,0 +1,112 @@
+package dashboard
+
+// indexHTML is the dashboard page. It draws the return curves of the up and
+// down agents with their rolling means and shows the latest step.
+const indexHTML = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>scup dashboard</title>
+<style>
+body { font-family: sans-serif; margin: 1em; }
+canvas { border: 1px solid #ccc; width: 100%; height: 240px; }
+table { border-collapse: collapse; margin-top: 1em; }
+td, th { border: 1px solid #ccc; padding: 2px 8px; text-align: right; font-family: monospace; }
+.up { color: #d62728; } .down { color: #1f77b4; }
+</style>
+</head>
+<body>
+<h1>scup</h1>
+<p id="status">connecting</p>
+<h2>Returns</h2>
+<canvas id="returns" width="960" height="240"></canvas>
+<p><span class="up">up</span> / <span class="down">down</span>, thin: episode, thick: rolling mean of <span id="window"></span></p>
+<h2>Latest step</h2>
+<table>
+<tr><th>agent</th><th>episode</th><th>step</th><th>state</th><th>action</th><th>reward</th><th>finish</th></tr>
+<tr id="step"><td colspan="7">-</td></tr>
+</table>
+<h2>Rewards</h2>
+<canvas id="rewards" width="960" height="120" style="height: 120px"></canvas>
+<script>
+const windowSize = 20;
+const maxRewards = 500;
+const colors = {up: "#d62728", down: "#1f77b4"};
+const returns = {up: [], down: []};
+const rewards = [];
+document.getElementById("window").textContent = windowSize;
+
+function rolling(xs) {
+  const res = [];
+  let sum = 0;
+  for (let i = 0; i < xs.length; i++) {
+    sum += xs[i];
+    if (i >= windowSize) sum -= xs[i - windowSize];
+    res.push(sum / Math.min(i + 1, windowSize));
+  }
+  return res;
+}
+
+function drawLines(canvas, series) {
+  const ctx = canvas.getContext("2d");
+  ctx.clearRect(0, 0, canvas.width, canvas.height);
+  let lo = Infinity, hi = -Infinity, n = 1;
+  for (const s of series) {
+    for (const v of s.ys) { lo = Math.min(lo, v); hi = Math.max(hi, v); }
+    n = Math.max(n, s.ys.length);
+  }
+  if (!isFinite(lo)) return;
+  if (lo === hi) { lo -= 1; hi += 1; }
+  const x = i => i / Math.max(n - 1, 1) * (canvas.width - 1);
+  const y = v => (hi - v) / (hi - lo) * (canvas.height - 1);
+  for (const s of series) {
+    ctx.strokeStyle = s.color;
+    ctx.lineWidth = s.width;
+    ctx.beginPath();
+    s.ys.forEach((v, i) => i ? ctx.lineTo(x(i), y(v)) : ctx.moveTo(x(i), y(v)));
+    ctx.stroke();
+  }
+  ctx.fillStyle = "#666";
+  ctx.fillText(hi.toPrecision(4), 2, 10);
+  ctx.fillText(lo.toPrecision(4), 2, canvas.height - 2);
+}
+
+function drawReturns() {
+  const series = [];
+  for (const agent of ["up", "down"]) {
+    series.push({ys: returns[agent], color: colors[agent] + "55", width: 1});
+    series.push({ys: rolling(returns[agent]), color: colors[agent], width: 2});
+  }
+  drawLines(document.getElementById("returns"), series);
+}
+
+function fmt(xs) {
+  return xs.map(v => v.toFixed(3)).join(" ");
+}
+
+const source = new EventSource("events");
+source.onopen = () => { document.getElementById("status").textContent = "connected"; };
+source.onerror = () => { document.getElementById("status").textContent = "disconnected"; };
+
+source.addEventListener("episode", e => {
+  const rec = JSON.parse(e.data);
+  if (rec.eval || !returns[rec.agent]) return;
+  returns[rec.agent].push(rec.returns);
+  drawReturns();
+});
+
+source.addEventListener("step", e => {
+  const rec = JSON.parse(e.data);
+  document.getElementById("step").innerHTML =
+    "<td class='" + rec.agent + "'>" + rec.agent + (rec.eval ? " (eval)" : "") + "</td>" +
+    "<td>" + rec.episode + "</td><td>" + rec.step + "</td>" +
+    "<td>" + fmt(rec.state) + "</td><td>" + fmt(rec.action) + "</td>" +
+    "<td>" + rec.reward.toFixed(3) + "</td><td>" + rec.finish + "</td>";
+  rewards.push(rec.reward);
+  if (rewards.length > maxRewards) rewards.shift();
+  drawLines(document.getElementById("rewards"), [{ys: rewards, color: "#333", width: 1}]);
+});
+</script>
+</body>
+</html>
+`
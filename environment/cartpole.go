@@ -3,18 +3,37 @@ package environment
 import (
 	"fmt"
 	"math"
+
+	"github.com/high-moctane/lab_scup2020/utils"
 )
 
 const CartpoleMaxAbsAction = 1.0
 const CartpoleMaxAbsThetaDot = 10.0
 
 type Cartpole struct {
+	utils.Randomness
+
 	g, m, l, dt, ml, mass float64
 
 	initState, s [4]float64 // [x, theta, xdot, thetadot]
+	resetNoise   float64
 }
 
 func (cp *Cartpole) Init() error {
+	cp.InitRand()
+
+	// 初期状態に加える一様ノイズの幅
+	resetNoise, err := utils.GetEnvFloat64Default("SCUP_ENV_RESET_NOISE", 0)
+	if err != nil {
+		return fmt.Errorf("cannot init cartpole: %w", err)
+	}
+	cp.resetNoise = resetNoise
+
+	cp.initModel()
+	return nil
+}
+
+func (cp *Cartpole) initModel() {
 	cp.g = 9.80665  // 重力加速度
 	cp.m = 0.1      // 棒の質量
 	cp.l = 0.5      // 棒の長さ
@@ -24,16 +43,27 @@ func (cp *Cartpole) Init() error {
 	cp.mass = cp.m + cartMass
 	cp.initState = [4]float64{0., math.Pi, 0., 0.}
 	cp.s = cp.initState
-	return nil
 }
 
 func (cp *Cartpole) Reset() error {
 	cp.s = cp.initState
+	if cp.resetNoise > 0 {
+		for i := range cp.s {
+			cp.s[i] += (cp.Rand().Float64()*2. - 1.) * cp.resetNoise
+		}
+		cp.s[1] = cp.normalize(cp.s[1])
+	}
 	return nil
 }
 
 func (cp *Cartpole) State() ([]float64, error) {
-	return cp.s[:], nil
+	// Copy so that the state does not change with the next step.
+	s := cp.s
+	return s[:], nil
+}
+
+func (cp *Cartpole) ActionMax() []float64 {
+	return []float64{CartpoleMaxAbsAction}
 }
 
 func (cp *Cartpole) RunStep(a []float64) error {
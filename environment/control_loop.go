@@ -0,0 +1,201 @@
+package environment
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/high-moctane/lab_scup2020/metrics"
+)
+
+var (
+	rrpControlLateness = metrics.NewHistogram(
+		"scup_rrp_control_lateness_seconds",
+		"Time a control step started after its deadline.",
+		[]float64{.0001, .0005, .001, .0025, .005, .01, .025, .05, .1, .25},
+	)
+	rrpControlJitter = metrics.NewHistogram(
+		"scup_rrp_control_jitter_seconds",
+		"Deviation of the time between two control steps from the period.",
+		[]float64{.0001, .0005, .001, .0025, .005, .01, .025, .05, .1, .25},
+	)
+	rrpControlOverruns = metrics.NewCounter("scup_rrp_control_overruns_total", "Control steps later than the max lateness.")
+)
+
+// RRPOverrunError is returned by RealRotatyPendulum.RunStep when the step
+// started more than the max lateness after its deadline. The step has been
+// run all the same, but the transition from the previous state is longer than
+// a control period.
+type RRPOverrunError struct {
+	Late   time.Duration
+	Missed int
+}
+
+func NewRRPOverrunError(late time.Duration, missed int) *RRPOverrunError {
+	return &RRPOverrunError{late, missed}
+}
+
+func (e *RRPOverrunError) Error() string {
+	return fmt.Sprintf("control step %v late (%d periods missed)", e.Late, e.Missed)
+}
+
+// RRPControlStats are the timing statistics of the control loop.
+type RRPControlStats struct {
+	Steps    uint64
+	Overruns uint64
+
+	// MaxLateness and TotalLateness are of the time each step started after
+	// its deadline.
+	MaxLateness   time.Duration
+	TotalLateness time.Duration
+
+	// MaxJitter and TotalJitter are of how much the time between two steps
+	// differed from the period. Intervals is the number of such pairs, which
+	// does not span a restart.
+	Intervals   uint64
+	MaxJitter   time.Duration
+	TotalJitter time.Duration
+}
+
+// MeanLateness returns the mean time a step started after its deadline.
+func (s RRPControlStats) MeanLateness() time.Duration {
+	if s.Steps == 0 {
+		return 0
+	}
+	return s.TotalLateness / time.Duration(s.Steps)
+}
+
+// MeanJitter returns the mean deviation of the time between two steps from
+// the period.
+func (s RRPControlStats) MeanJitter() time.Duration {
+	if s.Intervals == 0 {
+		return 0
+	}
+	return s.TotalJitter / time.Duration(s.Intervals)
+}
+
+// controlLoop paces the steps of a RealRotatyPendulum at a fixed period. The
+// deadlines are on a fixed grid and the loop sleeps until the next one, so the
+// time spent on the serial line and in the agent is absorbed by the wait
+// instead of being added to the period. A step which misses its deadline runs
+// at once and the loop rejoins the grid at the next deadline.
+//
+// A step is an overrun if it starts more than maxLateness after its deadline.
+type controlLoop struct {
+	period      time.Duration
+	maxLateness time.Duration
+
+	// now and sleep are time.Now and time.Sleep except in tests.
+	now   func() time.Time
+	sleep func(time.Duration)
+
+	started   bool
+	deadline  time.Time
+	lastStart time.Time
+
+	mu    sync.Mutex
+	stats RRPControlStats
+}
+
+// newControlLoop returns a controlLoop of period. A maxLateness of 0 means one
+// period, i.e. a step is an overrun when it misses its period.
+func newControlLoop(period, maxLateness time.Duration) *controlLoop {
+	if maxLateness <= 0 {
+		maxLateness = period
+	}
+	return &controlLoop{
+		period:      period,
+		maxLateness: maxLateness,
+		now:         time.Now,
+		sleep:       time.Sleep,
+	}
+}
+
+// wait blocks until the deadline of the next step. It returns
+// RRPOverrunError if the step starts too late.
+func (cl *controlLoop) wait() error {
+	if cl.period <= 0 {
+		cl.record(0, 0, false)
+		return nil
+	}
+
+	if !cl.started {
+		cl.started = true
+		cl.deadline = cl.now().Add(cl.period)
+		cl.lastStart = time.Time{}
+	}
+
+	if d := cl.deadline.Sub(cl.now()); d > 0 {
+		cl.sleep(d)
+	}
+
+	start := cl.now()
+	late := start.Sub(cl.deadline)
+	if late < 0 {
+		late = 0
+	}
+	missed := int(late / cl.period)
+	cl.deadline = cl.deadline.Add(time.Duration(missed+1) * cl.period)
+
+	var jitter time.Duration
+	interval := !cl.lastStart.IsZero()
+	if interval {
+		jitter = start.Sub(cl.lastStart) - cl.period
+		if jitter < 0 {
+			jitter = -jitter
+		}
+	}
+	cl.lastStart = start
+
+	cl.record(late, jitter, interval)
+
+	if late > cl.maxLateness {
+		return NewRRPOverrunError(late, missed)
+	}
+	return nil
+}
+
+func (cl *controlLoop) record(late, jitter time.Duration, interval bool) {
+	overrun := late > cl.maxLateness
+
+	rrpControlLateness.Observe(late.Seconds())
+	if interval {
+		rrpControlJitter.Observe(jitter.Seconds())
+	}
+	if overrun {
+		rrpControlOverruns.Inc()
+	}
+
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	cl.stats.Steps++
+	if overrun {
+		cl.stats.Overruns++
+	}
+	if late > cl.stats.MaxLateness {
+		cl.stats.MaxLateness = late
+	}
+	cl.stats.TotalLateness += late
+
+	if interval {
+		cl.stats.Intervals++
+		if jitter > cl.stats.MaxJitter {
+			cl.stats.MaxJitter = jitter
+		}
+		cl.stats.TotalJitter += jitter
+	}
+}
+
+// restart puts the next deadline a period from now, e.g. after an idle time
+// between episodes which should not count as an overrun.
+func (cl *controlLoop) restart() {
+	cl.started = false
+}
+
+// Stats returns the statistics. It is safe to call from another goroutine.
+func (cl *controlLoop) Stats() RRPControlStats {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	return cl.stats
+}
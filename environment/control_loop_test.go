@@ -0,0 +1,130 @@
+package environment
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// testClock is a clock for controlLoop which only moves when it sleeps or
+// when a test advances it.
+type testClock struct {
+	t time.Time
+}
+
+func (c *testClock) now() time.Time {
+	return c.t
+}
+
+func (c *testClock) sleep(d time.Duration) {
+	c.t = c.t.Add(d)
+}
+
+func newTestControlLoop(period, maxLateness time.Duration) (*controlLoop, *testClock) {
+	clock := &testClock{time.Unix(0, 0)}
+	cl := newControlLoop(period, maxLateness)
+	cl.now = clock.now
+	cl.sleep = clock.sleep
+	return cl, clock
+}
+
+func TestControlLoop(t *testing.T) {
+	period := 20 * time.Millisecond
+	cl, clock := newTestControlLoop(period, 0)
+
+	start := clock.now()
+	for i := 0; i < 5; i++ {
+		// Work shorter than the period is absorbed by the wait.
+		clock.sleep(period / 2)
+		if err := cl.wait(); err != nil {
+			t.Errorf("[%d] expected no overrun, but %v", i, err)
+		}
+	}
+	// The first deadline is a period after the first wait.
+	if elapsed := clock.now().Sub(start); elapsed != 5*period+period/2 {
+		t.Errorf("expected %v for 5 steps, but %v", 5*period+period/2, elapsed)
+	}
+
+	clock.sleep(3*period + period/2)
+	var overrun *RRPOverrunError
+	if err := cl.wait(); !errors.As(err, &overrun) {
+		t.Fatalf("expected RRPOverrunError, but %v", err)
+	}
+	if overrun.Missed != 2 || overrun.Late != 2*period+period/2 {
+		t.Errorf("expected 2 missed periods, but %d (%v late)", overrun.Missed, overrun.Late)
+	}
+
+	stats := cl.Stats()
+	if stats.Steps != 6 || stats.Overruns != 1 {
+		t.Errorf("expected 6 steps and 1 overrun, but %+v", stats)
+	}
+	if stats.MaxLateness != overrun.Late {
+		t.Errorf("expected max lateness %v, but %v", overrun.Late, stats.MaxLateness)
+	}
+	if stats.Intervals != 5 {
+		t.Errorf("expected 5 intervals, but %d", stats.Intervals)
+	}
+	// The overrun step came 3.5 periods after the previous one.
+	if stats.MaxJitter != 2*period+period/2 || stats.MeanJitter() != stats.MaxJitter/5 {
+		t.Errorf("expected max jitter %v, but %+v", 2*period+period/2, stats)
+	}
+
+	// The loop rejoins the grid after the overrun and waits for the next
+	// deadline instead of running at once.
+	before := clock.now()
+	if err := cl.wait(); err != nil {
+		t.Errorf("expected no overrun after rejoining, but %v", err)
+	}
+	if waited := clock.now().Sub(before); waited != period/2 {
+		t.Errorf("expected to wait %v for the grid, but %v", period/2, waited)
+	}
+}
+
+func TestControlLoop_maxLateness(t *testing.T) {
+	period := 10 * time.Millisecond
+	cl, clock := newTestControlLoop(period, 10*period)
+
+	cl.wait()
+	clock.sleep(3 * period)
+
+	// Missing a period is within the max lateness, so it is not an overrun
+	// either in the error or in the stats.
+	if err := cl.wait(); err != nil {
+		t.Errorf("expected no overrun, but %v", err)
+	}
+	if stats := cl.Stats(); stats.Overruns != 0 {
+		t.Errorf("expected no overruns, but %+v", stats)
+	}
+}
+
+func TestControlLoop_restart(t *testing.T) {
+	period := 10 * time.Millisecond
+	cl, clock := newTestControlLoop(period, 0)
+
+	cl.wait()
+	clock.sleep(5 * period)
+	cl.restart()
+
+	if err := cl.wait(); err != nil {
+		t.Errorf("expected no overrun after restart, but %v", err)
+	}
+	if stats := cl.Stats(); stats.Overruns != 0 || stats.Intervals != 0 {
+		t.Errorf("expected no overruns and intervals, but %+v", stats)
+	}
+}
+
+func TestControlLoop_realClock(t *testing.T) {
+	period := 10 * time.Millisecond
+	cl := newControlLoop(period, time.Second)
+
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		if err := cl.wait(); err != nil {
+			t.Errorf("[%d] expected no overrun, but %v", i, err)
+		}
+	}
+	// A slow scheduler only makes the steps later, never earlier.
+	if elapsed := time.Since(start); elapsed < 5*period {
+		t.Errorf("expected at least %v for 5 steps, but %v", 5*period, elapsed)
+	}
+}
@@ -4,9 +4,11 @@ import (
 	"fmt"
 	"os"
 
-	_ "github.com/high-moctane/lab_scup2020/logger"
+	"github.com/high-moctane/lab_scup2020/logger"
 )
 
+var lg = logger.Component("environment")
+
 type Environment interface {
 	Init() error
 	Reset() error
@@ -19,6 +21,18 @@ type Environment interface {
 	Close() error
 }
 
+// RawFramer is an Environment which reads its state from a device. LastFrame
+// returns the raw frame the current state is decoded from.
+type RawFramer interface {
+	LastFrame() []byte
+}
+
+// ActionRanger is an Environment whose actions are bounded. ActionMax returns
+// the max absolute value of each action dimension.
+type ActionRanger interface {
+	ActionMax() []float64
+}
+
 func SelectEnvironment() (Environment, error) {
 	envName, ok := os.LookupEnv("SCUP_ENV_NAME")
 	if !ok {
@@ -35,7 +49,7 @@ func SelectEnvironment() (Environment, error) {
 		return nil, fmt.Errorf("invalid env name")
 	}
 
-	// logger.Get().Info("env name: %s", envName)
+	lg.Info("env name: %s", envName)
 
 	return env, nil
 }
@@ -5,14 +5,16 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"io"
 	"math"
 	"time"
 
+	"github.com/high-moctane/lab_scup2020/metrics"
 	"github.com/high-moctane/lab_scup2020/utils"
-	"github.com/tarm/serial"
 )
 
 const RRPResetInput = 0.25
+const RRPMaxAbsAction = 1.0
 const RRPInitialBaseAngleRange = math.Pi / 32
 const RRPMaxBaseAngleRange = math.Pi / 2
 const RRPMaxTopPendulumAngleRange = math.Pi / 32
@@ -20,6 +22,11 @@ const RRPMaxTopPendulumVelocityRange = 10
 const RRPMaxBottomPendulumAngleRange = math.Pi * 31 / 32
 const RRPMaxBottomPendulumVelocityRange = 0.2 * math.Pi
 
+var (
+	rrpSerialTxErrors = metrics.NewCounter("scup_serial_tx_errors_total", "Serial writes shorter than a request.")
+	rrpSerialRxErrors = metrics.NewCounter("scup_serial_rx_errors_total", "Serial reads which timed out or ended with a bad response.")
+)
+
 type RRPSerialTxError struct {
 	n int
 }
@@ -44,50 +51,108 @@ func (e *RRPSerialRxError) Error() string {
 	return fmt.Sprintf("rx data len must be %d, but %d", RRPEncodedReceiveDataLen, e.n)
 }
 
+// RRPChecksumError is a full length response whose checksum does not match
+// its data.
+type RRPChecksumError struct {
+	expected, actual byte
+}
+
+func NewRRPChecksumError(expected, actual byte) *RRPChecksumError {
+	return &RRPChecksumError{expected, actual}
+}
+
+func (e *RRPChecksumError) Error() string {
+	return fmt.Sprintf("rx data checksum must be %#x, but %#x", e.expected, e.actual)
+}
+
+// RRPTransport is the byte stream to the pendulum firmware, e.g. the serial
+// port on the rig or a pipe to a VirtualPendulum.
+type RRPTransport interface {
+	io.ReadWriteCloser
+}
+
 type RealRotatyPendulum struct {
-	seri *serial.Port
+	seri   RRPTransport
+	fr     *RRPFrameReader
+	sensor *rrpSensor
 
 	dt time.Duration
+	cl *controlLoop
 
 	s, sPrev          *RRPState
+	lastFrame         []byte
+	received, sent    time.Time
 	initPendulumAngle float64
 
 	goodReward, badReward float64
 }
 
+// NewRealRotatyPendulum returns a RealRotatyPendulum on t. Init opens the
+// transport selected by SCUP_RRP_TRANSPORT if t is nil.
+func NewRealRotatyPendulum(t RRPTransport) *RealRotatyPendulum {
+	return &RealRotatyPendulum{seri: t}
+}
+
 func (rrp *RealRotatyPendulum) Init() error {
-	serialConf := serial.Config{
-		Name: "/dev/ttyAMA0",
-		Baud: 57600,
+	dtRaw, err := utils.GetEnvInt("SCUP_RRP_DT")
+	if err != nil {
+		return fmt.Errorf("cannot init real rotaty pendulum: %w", err)
 	}
-	seri, err := serial.OpenPort(&serialConf)
+	dt := time.Duration(dtRaw) * time.Millisecond
+
+	goodReward, err := utils.GetEnvFloat64("SCUP_RRP_BAD_REWARD")
 	if err != nil {
 		return fmt.Errorf("cannot init real rotaty pendulum: %w", err)
 	}
 
-	dtRaw, err := utils.GetEnvInt("SCUP_RRP_DT")
+	badReward, err := utils.GetEnvFloat64("SCUP_RRP_BAD_REWARD")
 	if err != nil {
 		return fmt.Errorf("cannot init real rotaty pendulum: %w", err)
 	}
-	dt := time.Duration(dtRaw) * time.Millisecond
 
-	goodReward, err := utils.GetEnvFloat64("SCUP_RRP_BAD_REWARD")
+	// RunStep returns RRPOverrunError for steps later than this (0 one
+	// period).
+	maxLatenessRaw, err := utils.GetEnvIntDefault("SCUP_RRP_MAX_LATENESS", 0)
 	if err != nil {
 		return fmt.Errorf("cannot init real rotaty pendulum: %w", err)
 	}
 
-	badReward, err := utils.GetEnvFloat64("SCUP_RRP_BAD_REWARD")
+	// Read the sensors in the background instead of after each request.
+	asyncRead, err := utils.GetEnvIntDefault("SCUP_RRP_ASYNC_READ", 0)
 	if err != nil {
 		return fmt.Errorf("cannot init real rotaty pendulum: %w", err)
 	}
 
-	rrp.seri = seri
+	if rrp.seri == nil {
+		if err := checkAsyncRead(asyncRead != 0); err != nil {
+			return fmt.Errorf("cannot init real rotaty pendulum: %w", err)
+		}
+
+		seri, err := openRRPTransport()
+		if err != nil {
+			return fmt.Errorf("cannot init real rotaty pendulum: %w", err)
+		}
+		rrp.seri = seri
+	}
+	rrp.fr = NewRRPFrameReader(rrp.seri)
+	if asyncRead != 0 {
+		rrp.sensor = newRRPSensor(rrp.fr)
+	}
+
 	rrp.dt = dt
+	rrp.cl = newControlLoop(dt, time.Duration(maxLatenessRaw)*time.Millisecond)
 	rrp.goodReward = goodReward
 	rrp.badReward = badReward
 
+	var rxError *RRPSerialRxError
+	var checksumError *RRPChecksumError
+	var overrunError *RRPOverrunError
+
 	for rrp.sPrev == nil {
-		rrp.RunStep([]float64{0})
+		err := rrp.RunStep([]float64{0})
+		if err != nil && !errors.As(err, &rxError) && !errors.As(err, &checksumError) && !errors.As(err, &overrunError) {
+			return fmt.Errorf("cannot init real rotaty pendulum: %w", err)
+		}
 	}
 	rrp.initPendulumAngle = rrp.s.ToState(rrp.sPrev)[1]
 
@@ -96,6 +161,11 @@ func (rrp *RealRotatyPendulum) Init() error {
 
 func (rrp *RealRotatyPendulum) Reset() error {
 	var rxError *RRPSerialRxError
+	var checksumError *RRPChecksumError
+	var overrunError *RRPOverrunError
+
+	// The time since the last episode is not an overrun.
+	rrp.cl.restart()
 
 	for {
 		if rrp.s != nil && math.Abs(rrp.s.BaseAngle) < RRPInitialBaseAngleRange {
@@ -109,7 +179,7 @@ func (rrp *RealRotatyPendulum) Reset() error {
 		}
 
 		if err := rrp.RunStep([]float64{direction * RRPResetInput}); err != nil {
-			if errors.As(err, &rxError) {
+			if errors.As(err, &rxError) || errors.As(err, &checksumError) || errors.As(err, &overrunError) {
 				continue
 			}
 			return fmt.Errorf("reset error: %w", err)
@@ -117,20 +187,30 @@ func (rrp *RealRotatyPendulum) Reset() error {
 	}
 }
 
+// State returns the state read after the last request. With
+// SCUP_RRP_ASYNC_READ it waits up to a control period for the response to
+// the last request and returns the latest state read if none comes.
 func (rrp *RealRotatyPendulum) State() (s []float64, err error) {
+	if rrp.sensor != nil {
+		if err := rrp.observe(rrp.sent, rrp.dt); err != nil {
+			return nil, err
+		}
+	}
+	rrpStateAge.Observe(rrp.StateAge().Seconds())
+
 	s = rrp.s.ToState(rrp.sPrev)
 	s[1] = relativeAngle(rrp.initPendulumAngle, s[1])
 	return s, nil
 }
 
 func (rrp *RealRotatyPendulum) RunStep(a []float64) error {
-	// TODO
-	time.Sleep(rrp.dt)
-
-	// Send
 	if len(a) != 1 {
 		panic(fmt.Errorf("action len must be 1, but %d", len(a)))
 	}
+
+	overrun := rrp.cl.wait()
+
+	// Send
 	sendData := NewRRPSendData(a[0])
 
 	n, err := rrp.seri.Write(sendData.ToBytes())
@@ -138,18 +218,36 @@ func (rrp *RealRotatyPendulum) RunStep(a []float64) error {
 		return fmt.Errorf("run step error: %w", err)
 	}
 	if n != RRPSendDataLen {
+		rrpSerialTxErrors.Inc()
 		return NewRRPSerialTxError(n)
 	}
+	rrp.sent = time.Now()
 
 	// Receive
-	buf := make([]byte, 14)
-	n, err = rrp.seri.Read(buf)
+	if rrp.sensor != nil {
+		// State waits for the response.
+		if err := rrp.observe(rrp.sent, 0); err != nil {
+			return fmt.Errorf("run step error: %w", err)
+		}
+		return overrun
+	}
+
+	var rxError *RRPSerialRxError
+	var checksumError *RRPChecksumError
+
+	buf, err := rrp.fr.ReadFrame()
+	if errors.As(err, &rxError) || errors.As(err, &checksumError) {
+		rrpSerialRxErrors.Inc()
+		return err
+	}
+	if errors.Is(err, io.EOF) {
+		// The read timed out before a whole frame arrived.
+		rrpSerialRxErrors.Inc()
+		return NewRRPSerialRxError(rrp.fr.Buffered())
+	}
 	if err != nil {
 		return fmt.Errorf("run step error: %w", err)
 	}
-	if n != RRPEncodedReceiveDataLen {
-		return NewRRPSerialRxError(n)
-	}
 	encData, err := NewRRPEncodedReceiveData(buf)
 	if err != nil {
 		return fmt.Errorf("run step error: %w", err)
@@ -162,10 +260,53 @@ func (rrp *RealRotatyPendulum) RunStep(a []float64) error {
 
 	// Update
 	rrp.s, rrp.sPrev = s, rrp.s
+	rrp.lastFrame = buf
+	rrp.received = time.Now()
 
+	return overrun
+}
+
+// observe takes the latest state of the sensor reader, waiting up to timeout
+// for one read after t.
+func (rrp *RealRotatyPendulum) observe(t time.Time, timeout time.Duration) error {
+	o, err := rrp.sensor.observationAfter(t, timeout)
+	if err != nil {
+		return err
+	}
+	if o.S == nil {
+		return nil
+	}
+
+	rrp.s, rrp.sPrev = o.S, o.SPrev
+	rrp.lastFrame = o.Frame
+	rrp.received = o.Received
 	return nil
 }
 
+// StateAge returns how long ago the current state was read from the sensors.
+func (rrp *RealRotatyPendulum) StateAge() time.Duration {
+	return time.Since(rrp.received)
+}
+
+// ActionMax returns the range of the motor input the device takes.
+func (rrp *RealRotatyPendulum) ActionMax() []float64 {
+	return []float64{RRPMaxAbsAction}
+}
+
+func (rrp *RealRotatyPendulum) LastFrame() []byte {
+	return append([]byte(nil), rrp.lastFrame...)
+}
+
+// ControlStats returns the timing statistics of the control loop.
+func (rrp *RealRotatyPendulum) ControlStats() RRPControlStats {
+	return rrp.cl.Stats()
+}
+
+// FrameStats returns the counters of the frames read from the transport.
+func (rrp *RealRotatyPendulum) FrameStats() RRPFrameStats {
+	return rrp.fr.Stats()
+}
+
 func (rrp *RealRotatyPendulum) IsFinishUp(s []float64) bool {
 	baseAngle := math.Abs(s[0])
 	pendAngle := math.Abs(relativeAngle(rrp.initPendulumAngle, s[1]))
@@ -209,17 +350,80 @@ func (rrp *RealRotatyPendulum) RewardFuncDown() func(s []float64) float64 {
 }
 
 func (rrp *RealRotatyPendulum) Close() error {
-	if err := rrp.RunStep([]float64{0.}); err != nil {
+	var overrunError *RRPOverrunError
+
+	if err := rrp.RunStep([]float64{0.}); err != nil && !errors.As(err, &overrunError) {
 		return fmt.Errorf("rrp close error: %w", err)
 	}
-
+	if rrp.sensor != nil {
+		rrp.sensor.close()
+	}
 	if err := rrp.seri.Close(); err != nil {
 		return fmt.Errorf("rrp close error: %w", err)
 	}
+	if rrp.sensor != nil {
+		rrp.sensor.wait()
+	}
+
+	stats := rrp.fr.Stats()
+	lg.With(
+		"frames", stats.Frames,
+		"dropped", stats.Dropped,
+		"corrupted", stats.Corrupted,
+		"garbage_bytes", stats.GarbageBytes,
+	).Info("serial frames")
+
+	control := rrp.cl.Stats()
+	lg.With(
+		"steps", control.Steps,
+		"overruns", control.Overruns,
+		"mean_lateness", control.MeanLateness(),
+		"max_lateness", control.MaxLateness,
+		"mean_jitter", control.MeanJitter(),
+		"max_jitter", control.MaxJitter,
+	).Info("control loop")
 
 	return nil
 }
 
+// checkAsyncRead returns an error if the sensor reader could not be stopped:
+// closing a serial port does not end a blocking read, so the read must time
+// out.
+func checkAsyncRead(asyncRead bool) error {
+	if !asyncRead || utils.GetEnvStringDefault("SCUP_RRP_TRANSPORT", "serial") != "serial" {
+		return nil
+	}
+
+	timeout, err := utils.GetEnvIntDefault("SCUP_SERIAL_READ_TIMEOUT", 0)
+	if err != nil {
+		return err
+	}
+	if timeout <= 0 {
+		return fmt.Errorf("SCUP_RRP_ASYNC_READ needs a positive SCUP_SERIAL_READ_TIMEOUT")
+	}
+	return nil
+}
+
+// openRRPTransport opens the transport selected by SCUP_RRP_TRANSPORT:
+// "serial" (default) for the rig configured by the SCUP_SERIAL_* envs or
+// "virtual" for an in-process VirtualPendulum. The virtual pendulum follows
+// the wall clock unless SCUP_RRP_VIRTUAL_STEP gives milliseconds to advance
+// per request.
+func openRRPTransport() (RRPTransport, error) {
+	switch name := utils.GetEnvStringDefault("SCUP_RRP_TRANSPORT", "serial"); name {
+	case "serial":
+		return OpenSerial()
+	case "virtual":
+		step, err := utils.GetEnvIntDefault("SCUP_RRP_VIRTUAL_STEP", 0)
+		if err != nil {
+			return nil, err
+		}
+		return NewVirtualTransport(NewVirtualPendulum(time.Duration(step) * time.Millisecond)), nil
+	default:
+		return nil, fmt.Errorf("invalid SCUP_RRP_TRANSPORT: %s", name)
+	}
+}
+
 func relativeAngle(theta, other float64) float64 {
 	res := other - theta
 	if res < -math.Pi {
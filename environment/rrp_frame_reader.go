@@ -0,0 +1,143 @@
+package environment
+
+import (
+	"bytes"
+	"io"
+	"sync/atomic"
+
+	"github.com/high-moctane/lab_scup2020/metrics"
+)
+
+var (
+	rrpDroppedFrames   = metrics.NewCounter("scup_serial_rx_dropped_frames_total", "Incomplete RRP frames discarded while resynchronizing.")
+	rrpCorruptedFrames = metrics.NewCounter("scup_serial_rx_corrupted_frames_total", "RRP frames discarded for a bad checksum.")
+)
+
+// rrpFrameReaderMaxPending is the number of bytes without a terminator kept
+// before the older ones are discarded as garbage.
+const rrpFrameReaderMaxPending = 4 * RRPEncodedReceiveDataLen
+
+// RRPFrameStats are the counters of an RRPFrameReader.
+type RRPFrameStats struct {
+	// Frames is the number of valid frames read.
+	Frames uint64
+
+	// Dropped is the number of frames discarded because they were shorter
+	// than a response, e.g. the tail of a frame cut off by a reset.
+	Dropped uint64
+
+	// Corrupted is the number of full length frames with a bad checksum.
+	Corrupted uint64
+
+	// GarbageBytes is the number of bytes discarded outside of full length
+	// frames, including those of dropped frames.
+	GarbageBytes uint64
+}
+
+// RRPFrameReader reads RRP responses from a byte stream. A response always
+// ends with '\n' and none of the encoded bytes can be '\n', so the reader
+// takes the RRPEncodedReceiveDataLen bytes up to each terminator as a frame
+// and discards everything else. Reads split across calls are reassembled.
+type RRPFrameReader struct {
+	r       io.Reader
+	pending []byte
+	buf     []byte
+
+	frames, dropped, corrupted, garbageBytes uint64
+}
+
+func NewRRPFrameReader(r io.Reader) *RRPFrameReader {
+	return &RRPFrameReader{
+		r:   r,
+		buf: make([]byte, 2*RRPEncodedReceiveDataLen),
+	}
+}
+
+// ReadFrame returns the next frame with a valid checksum. The firmware only
+// answers requests, so once a frame is discarded and no valid one is
+// buffered it returns *RRPSerialRxError for a short frame or
+// *RRPChecksumError for a corrupted one instead of waiting for bytes which may
+// never come. It returns the error of the underlying reader if the stream ends
+// before a frame is found.
+func (fr *RRPFrameReader) ReadFrame() ([]byte, error) {
+	for {
+		frame, discarded := fr.nextFrame()
+		if frame != nil {
+			return frame, nil
+		}
+		if discarded != nil {
+			return nil, discarded
+		}
+
+		n, err := fr.r.Read(fr.buf)
+		fr.pending = append(fr.pending, fr.buf[:n]...)
+		if n > 0 {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// nextFrame takes the first valid frame out of the pending bytes. It returns
+// the error of the last frame discarded on the way, or nil if none was.
+func (fr *RRPFrameReader) nextFrame() (frame []byte, discarded error) {
+	for {
+		i := bytes.IndexByte(fr.pending, '\n')
+		if i < 0 {
+			if over := len(fr.pending) - rrpFrameReaderMaxPending; over > 0 {
+				fr.discard(over)
+			}
+			return nil, discarded
+		}
+
+		if i+1 < RRPEncodedReceiveDataLen {
+			atomic.AddUint64(&fr.dropped, 1)
+			rrpDroppedFrames.Inc()
+			discarded = NewRRPSerialRxError(i + 1)
+			fr.discard(i + 1)
+			continue
+		}
+
+		start := i + 1 - RRPEncodedReceiveDataLen
+		fr.discard(start)
+
+		candidate := fr.pending[:RRPEncodedReceiveDataLen]
+		if sum := calcCheckSum(candidate[:12]); sum != candidate[12] {
+			atomic.AddUint64(&fr.corrupted, 1)
+			rrpCorruptedFrames.Inc()
+			discarded = NewRRPChecksumError(sum, candidate[12])
+			fr.pending = fr.pending[RRPEncodedReceiveDataLen:]
+			continue
+		}
+
+		frame = append([]byte(nil), candidate...)
+		fr.pending = fr.pending[RRPEncodedReceiveDataLen:]
+		atomic.AddUint64(&fr.frames, 1)
+		return frame, discarded
+	}
+}
+
+func (fr *RRPFrameReader) discard(n int) {
+	if n == 0 {
+		return
+	}
+	atomic.AddUint64(&fr.garbageBytes, uint64(n))
+	fr.pending = fr.pending[n:]
+}
+
+// Buffered returns the number of bytes read but not yet returned as a frame.
+func (fr *RRPFrameReader) Buffered() int {
+	return len(fr.pending)
+}
+
+// Stats returns the counters. It is safe to call from another goroutine.
+func (fr *RRPFrameReader) Stats() RRPFrameStats {
+	return RRPFrameStats{
+		Frames:       atomic.LoadUint64(&fr.frames),
+		Dropped:      atomic.LoadUint64(&fr.dropped),
+		Corrupted:    atomic.LoadUint64(&fr.corrupted),
+		GarbageBytes: atomic.LoadUint64(&fr.garbageBytes),
+	}
+}
@@ -0,0 +1,151 @@
+package environment
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+func testFrames(n int) [][]byte {
+	vp := NewVirtualPendulum(10 * time.Millisecond)
+	res := make([][]byte, n)
+	for i := range res {
+		res[i] = vp.Request(0.1)
+	}
+	return res
+}
+
+func TestRRPFrameReader(t *testing.T) {
+	frames := testFrames(3)
+	corrupted := append([]byte(nil), frames[1]...)
+	corrupted[12] ^= 0x01
+
+	tests := []struct {
+		stream   []byte
+		expected [][]byte
+		stats    RRPFrameStats
+	}{
+		{
+			bytes.Join(frames, nil),
+			frames,
+			RRPFrameStats{Frames: 3},
+		},
+		{
+			// Shifted by the tail of a cut off frame.
+			bytes.Join([][]byte{frames[0][9:], frames[1], frames[2]}, nil),
+			frames[1:],
+			RRPFrameStats{Frames: 2, Dropped: 1, GarbageBytes: 5},
+		},
+		{
+			// Garbage in front of a frame.
+			bytes.Join([][]byte{{0xff, 0x00, 0x31}, frames[0], frames[1]}, nil),
+			frames[:2],
+			RRPFrameStats{Frames: 2, GarbageBytes: 3},
+		},
+		{
+			bytes.Join([][]byte{frames[0], corrupted, frames[2]}, nil),
+			[][]byte{frames[0], frames[2]},
+			RRPFrameStats{Frames: 2, Corrupted: 1},
+		},
+	}
+
+	for i, test := range tests {
+		for _, split := range []bool{false, true} {
+			var r io.Reader = bytes.NewReader(test.stream)
+			if split {
+				r = iotest.OneByteReader(r)
+			}
+			fr := NewRRPFrameReader(r)
+
+			for j, expected := range test.expected {
+				// A discarded frame is reported before the next one is read,
+				// as RunStep would send a new request.
+				var rxError *RRPSerialRxError
+				var checksumError *RRPChecksumError
+				frame, err := fr.ReadFrame()
+				if errors.As(err, &rxError) || errors.As(err, &checksumError) {
+					frame, err = fr.ReadFrame()
+				}
+				if err != nil {
+					t.Fatalf("[%d %v %d] unexpected error: %v", i, split, j, err)
+				}
+				if !bytes.Equal(frame, expected) {
+					t.Errorf("[%d %v %d] expected %q, but %q", i, split, j, expected, frame)
+				}
+			}
+			if _, err := fr.ReadFrame(); err != io.EOF {
+				t.Errorf("[%d %v] expected EOF, but %v", i, split, err)
+			}
+			if stats := fr.Stats(); stats != test.stats {
+				t.Errorf("[%d %v] expected %+v, but %+v", i, split, test.stats, stats)
+			}
+		}
+	}
+}
+
+func TestRRPFrameReader_noTerminator(t *testing.T) {
+	stream := bytes.Repeat([]byte{0x31}, 10*RRPEncodedReceiveDataLen)
+	fr := NewRRPFrameReader(bytes.NewReader(stream))
+
+	if _, err := fr.ReadFrame(); err != io.EOF {
+		t.Errorf("expected EOF, but %v", err)
+	}
+	if fr.Buffered() > rrpFrameReaderMaxPending {
+		t.Errorf("expected at most %d bytes buffered, but %d", rrpFrameReaderMaxPending, fr.Buffered())
+	}
+}
+
+func TestRRPFrameReader_discardedThenSilent(t *testing.T) {
+	corrupted := testFrames(1)[0]
+	corrupted[12] ^= 0x01
+
+	tests := []struct {
+		frame    []byte
+		checksum bool
+		stats    RRPFrameStats
+	}{
+		{
+			// The tail of a cut off frame.
+			testFrames(1)[0][9:],
+			false,
+			RRPFrameStats{Dropped: 1, GarbageBytes: 5},
+		},
+		{
+			corrupted,
+			true,
+			RRPFrameStats{Corrupted: 1},
+		},
+	}
+
+	for i, test := range tests {
+		r, w := io.Pipe()
+		go w.Write(test.frame)
+
+		fr := NewRRPFrameReader(r)
+
+		done := make(chan error, 1)
+		go func() {
+			_, err := fr.ReadFrame()
+			done <- err
+		}()
+
+		select {
+		case err := <-done:
+			var rxError *RRPSerialRxError
+			var checksumError *RRPChecksumError
+			if errors.As(err, &rxError) == test.checksum || errors.As(err, &checksumError) != test.checksum {
+				t.Errorf("[%d] expected checksum error %v, but %v", i, test.checksum, err)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("[%d] ReadFrame blocked after a discarded frame", i)
+		}
+		w.Close()
+
+		if stats := fr.Stats(); stats != test.stats {
+			t.Errorf("[%d] expected %+v, but %+v", i, test.stats, stats)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package environment
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/high-moctane/lab_scup2020/metrics"
+)
+
+var (
+	rrpStateAge = metrics.NewHistogram(
+		"scup_rrp_state_age_seconds",
+		"Age of the latest sensor state when it is observed.",
+		[]float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+	)
+	rrpSensorDecodeErrors = metrics.NewCounter("scup_rrp_sensor_decode_errors_total", "Frames the sensor reader could not decode.")
+)
+
+// rrpObservation is a state read by the sensor reader.
+type rrpObservation struct {
+	S, SPrev *RRPState
+	Frame    []byte
+
+	// Received is the wall-clock time the frame was read. The firmware time
+	// is in S.TimeStamp.
+	Received time.Time
+
+	// Seq counts the frames read so far.
+	Seq uint64
+}
+
+// rrpSensor reads RRP frames in the background and keeps the latest state so
+// that actions can be sent without waiting for their responses.
+type rrpSensor struct {
+	fr *RRPFrameReader
+
+	mu      sync.Mutex
+	latest  rrpObservation
+	err     error
+	updated chan struct{} // closed and replaced on each observation
+
+	closing int32
+	done    chan struct{}
+}
+
+func newRRPSensor(fr *RRPFrameReader) *rrpSensor {
+	rs := &rrpSensor{fr: fr, updated: make(chan struct{}), done: make(chan struct{})}
+	go rs.run()
+	return rs
+}
+
+func (rs *rrpSensor) run() {
+	defer close(rs.done)
+
+	var rxError *RRPSerialRxError
+	var checksumError *RRPChecksumError
+
+	for {
+		buf, err := rs.fr.ReadFrame()
+		if err != nil {
+			if atomic.LoadInt32(&rs.closing) == 1 {
+				return
+			}
+			if errors.As(err, &rxError) || errors.As(err, &checksumError) {
+				// The bad frame is counted by the frame reader.
+				continue
+			}
+			if errors.Is(err, io.EOF) {
+				// Read timeout while the firmware is silent.
+				continue
+			}
+			rs.mu.Lock()
+			rs.err = fmt.Errorf("sensor read error: %w", err)
+			rs.mu.Unlock()
+			return
+		}
+
+		encData, err := NewRRPEncodedReceiveData(buf)
+		if err != nil {
+			rrpSensorDecodeErrors.Inc()
+			lg.Debug("sensor decode error: %v", err)
+			continue
+		}
+		rsvData, err := encData.ToRRPReceiveData()
+		if err != nil {
+			rrpSensorDecodeErrors.Inc()
+			lg.Debug("sensor decode error: %v", err)
+			continue
+		}
+		s := rsvData.ToRRPState()
+
+		rs.mu.Lock()
+		rs.latest = rrpObservation{
+			S:        s,
+			SPrev:    rs.latest.S,
+			Frame:    buf,
+			Received: time.Now(),
+			Seq:      rs.latest.Seq + 1,
+		}
+		close(rs.updated)
+		rs.updated = make(chan struct{})
+		rs.mu.Unlock()
+	}
+}
+
+// observationAfter returns the first observation received after t, or the
+// latest one if none is received within timeout. Its S is nil until the first
+// frame. It returns the error which stopped the reader if any.
+func (rs *rrpSensor) observationAfter(t time.Time, timeout time.Duration) (rrpObservation, error) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for {
+		rs.mu.Lock()
+		o, err, updated := rs.latest, rs.err, rs.updated
+		rs.mu.Unlock()
+
+		if err != nil || o.Received.After(t) {
+			return o, err
+		}
+
+		select {
+		case <-updated:
+		case <-timer.C:
+			return o, nil
+		case <-rs.done:
+			rs.mu.Lock()
+			defer rs.mu.Unlock()
+			return rs.latest, rs.err
+		}
+	}
+}
+
+// close marks the reader as stopping so that the error of the transport being
+// closed is not reported. The transport must be closed next to end the read.
+func (rs *rrpSensor) close() {
+	atomic.StoreInt32(&rs.closing, 1)
+}
+
+// wait blocks until the reader stops.
+func (rs *rrpSensor) wait() {
+	<-rs.done
+}
@@ -0,0 +1,75 @@
+package environment
+
+import (
+	"math"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestRealRotatyPendulumAsyncRead(t *testing.T) {
+	for k, v := range map[string]string{
+		"SCUP_RRP_DT":           "20",
+		"SCUP_RRP_GOOD_REWARD":  "1000",
+		"SCUP_RRP_BAD_REWARD":   "-1000",
+		"SCUP_RRP_ASYNC_READ":   "1",
+		"SCUP_RRP_MAX_LATENESS": "1000",
+	} {
+		os.Setenv(k, v)
+		defer os.Unsetenv(k)
+	}
+
+	vp := NewVirtualPendulum(50 * time.Millisecond)
+	rrp := NewRealRotatyPendulum(NewVirtualTransport(vp))
+	if err := rrp.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 10; i++ {
+		if err := rrp.RunStep([]float64{0.5}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// State waits for the response to the last request.
+	s, err := rrp.State()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := vp.State()[0]; math.Abs(s[0]-expected) > 1e-4 {
+		t.Errorf("expected base angle %v, but %v", expected, s[0])
+	}
+
+	if age := rrp.StateAge(); age < 0 || age > time.Second {
+		t.Errorf("expected state age under a second, but %v", age)
+	}
+
+	if err := rrp.Close(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-rrp.sensor.done:
+	default:
+		t.Error("expected the sensor reader to stop")
+	}
+}
+
+func TestRealRotatyPendulumAsyncReadNeedsTimeout(t *testing.T) {
+	for k, v := range map[string]string{
+		"SCUP_RRP_DT":          "20",
+		"SCUP_RRP_GOOD_REWARD": "1000",
+		"SCUP_RRP_BAD_REWARD":  "-1000",
+		"SCUP_RRP_ASYNC_READ":  "1",
+		"SCUP_RRP_TRANSPORT":   "serial",
+		"SCUP_SERIAL_PORT":     "/dev/null",
+	} {
+		os.Setenv(k, v)
+		defer os.Unsetenv(k)
+	}
+
+	rrp := NewRealRotatyPendulum(nil)
+	if err := rrp.Init(); err == nil {
+		rrp.Close()
+		t.Fatal("expected an error without SCUP_SERIAL_READ_TIMEOUT")
+	}
+}
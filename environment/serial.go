@@ -0,0 +1,151 @@
+package environment
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"time"
+
+	"github.com/high-moctane/lab_scup2020/utils"
+	"github.com/tarm/serial"
+)
+
+// SerialAutoDetect is the port name which selects the first USB serial port.
+const SerialAutoDetect = "auto"
+
+// serialEnvs are the env names of the serial settings with their flag names,
+// defaults and usages.
+var serialEnvs = []struct {
+	env, flag, def, usage string
+}{
+	{"SCUP_SERIAL_PORT", "port", "/dev/ttyAMA0", `serial port, or "auto" to detect a USB serial port`},
+	{"SCUP_SERIAL_BAUD", "baud", "57600", "baud rate"},
+	{"SCUP_SERIAL_READ_TIMEOUT", "read-timeout", "0", "read timeout in milliseconds (0 blocks)"},
+	{"SCUP_SERIAL_PARITY", "parity", "N", "parity: N, O or E"},
+	{"SCUP_SERIAL_STOP_BITS", "stop-bits", "1", "stop bits: 1 or 2"},
+}
+
+// envFlag is a flag which sets an env. As godotenv does not override envs,
+// flags take precedence over the env file whenever it is loaded.
+type envFlag struct {
+	env, def string
+}
+
+func (f *envFlag) String() string {
+	if f == nil {
+		return ""
+	}
+	if v, ok := os.LookupEnv(f.env); ok {
+		return v
+	}
+	return f.def
+}
+
+func (f *envFlag) Set(v string) error {
+	return os.Setenv(f.env, v)
+}
+
+// AddSerialFlags adds flags for the SCUP_SERIAL_* settings to fs.
+func AddSerialFlags(fs *flag.FlagSet) {
+	for _, e := range serialEnvs {
+		fs.Var(&envFlag{e.env, e.def}, e.flag, e.usage+" ("+e.env+")")
+	}
+}
+
+// NewSerialConfig makes a serial config from the env:
+//
+//	SCUP_SERIAL_PORT          port (default /dev/ttyAMA0, "auto" to detect)
+//	SCUP_SERIAL_BAUD          baud rate (default 57600)
+//	SCUP_SERIAL_READ_TIMEOUT  read timeout in milliseconds (default 0, blocking)
+//	SCUP_SERIAL_PARITY        N (default), O or E
+//	SCUP_SERIAL_STOP_BITS     1 (default) or 2
+func NewSerialConfig() (*serial.Config, error) {
+	name := utils.GetEnvStringDefault("SCUP_SERIAL_PORT", "/dev/ttyAMA0")
+	if name == SerialAutoDetect {
+		var err error
+		name, err = detectUSBSerial()
+		if err != nil {
+			return nil, fmt.Errorf("cannot make serial config: %w", err)
+		}
+	}
+
+	baud, err := utils.GetEnvIntDefault("SCUP_SERIAL_BAUD", 57600)
+	if err != nil {
+		return nil, fmt.Errorf("cannot make serial config: %w", err)
+	}
+
+	timeout, err := utils.GetEnvIntDefault("SCUP_SERIAL_READ_TIMEOUT", 0)
+	if err != nil {
+		return nil, fmt.Errorf("cannot make serial config: %w", err)
+	}
+
+	var parity serial.Parity
+	switch p := utils.GetEnvStringDefault("SCUP_SERIAL_PARITY", "N"); p {
+	case "N":
+		parity = serial.ParityNone
+	case "O":
+		parity = serial.ParityOdd
+	case "E":
+		parity = serial.ParityEven
+	default:
+		return nil, fmt.Errorf("cannot make serial config: invalid parity %s", p)
+	}
+
+	var stopBits serial.StopBits
+	switch s := utils.GetEnvStringDefault("SCUP_SERIAL_STOP_BITS", "1"); s {
+	case "1":
+		stopBits = serial.Stop1
+	case "2":
+		stopBits = serial.Stop2
+	default:
+		return nil, fmt.Errorf("cannot make serial config: invalid stop bits %s", s)
+	}
+
+	return &serial.Config{
+		Name:        name,
+		Baud:        baud,
+		ReadTimeout: time.Duration(timeout) * time.Millisecond,
+		Parity:      parity,
+		StopBits:    stopBits,
+	}, nil
+}
+
+// OpenSerial opens the serial port configured by the env.
+func OpenSerial() (*serial.Port, error) {
+	c, err := NewSerialConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := serial.OpenPort(c)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open serial %s: %w", c.Name, err)
+	}
+
+	lg.With("port", c.Name, "baud", c.Baud).Info("serial opened")
+	return s, nil
+}
+
+// usbSerialPatterns are the globs of USB serial ports by GOOS in order of
+// preference.
+var usbSerialPatterns = map[string][]string{
+	"linux":  {"/dev/serial/by-id/*", "/dev/ttyUSB*", "/dev/ttyACM*"},
+	"darwin": {"/dev/tty.usbserial*", "/dev/tty.usbmodem*"},
+}
+
+func detectUSBSerial() (string, error) {
+	for _, pattern := range usbSerialPatterns[runtime.GOOS] {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return "", err
+		}
+		if len(matches) > 0 {
+			sort.Strings(matches)
+			return matches[0], nil
+		}
+	}
+	return "", fmt.Errorf("no USB serial port found")
+}
@@ -0,0 +1,96 @@
+package environment
+
+import (
+	"flag"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/tarm/serial"
+)
+
+func setSerialEnvs(t *testing.T, envs map[string]string) {
+	for _, e := range serialEnvs {
+		prev, ok := os.LookupEnv(e.env)
+		os.Unsetenv(e.env)
+		env := e.env
+		t.Cleanup(func() {
+			if ok {
+				os.Setenv(env, prev)
+			} else {
+				os.Unsetenv(env)
+			}
+		})
+	}
+	for k, v := range envs {
+		os.Setenv(k, v)
+	}
+}
+
+func TestNewSerialConfig(t *testing.T) {
+	tests := []struct {
+		envs     map[string]string
+		expected *serial.Config
+		err      bool
+	}{
+		{
+			map[string]string{},
+			&serial.Config{Name: "/dev/ttyAMA0", Baud: 57600, Parity: serial.ParityNone, StopBits: serial.Stop1},
+			false,
+		},
+		{
+			map[string]string{
+				"SCUP_SERIAL_PORT":         "/dev/ttyUSB0",
+				"SCUP_SERIAL_BAUD":         "115200",
+				"SCUP_SERIAL_READ_TIMEOUT": "100",
+				"SCUP_SERIAL_PARITY":       "E",
+				"SCUP_SERIAL_STOP_BITS":    "2",
+			},
+			&serial.Config{Name: "/dev/ttyUSB0", Baud: 115200, ReadTimeout: 100 * time.Millisecond, Parity: serial.ParityEven, StopBits: serial.Stop2},
+			false,
+		},
+		{map[string]string{"SCUP_SERIAL_BAUD": "fast"}, nil, true},
+		{map[string]string{"SCUP_SERIAL_PARITY": "X"}, nil, true},
+		{map[string]string{"SCUP_SERIAL_STOP_BITS": "3"}, nil, true},
+		// tarm/serial cannot set them on Linux.
+		{map[string]string{"SCUP_SERIAL_PARITY": "M"}, nil, true},
+		{map[string]string{"SCUP_SERIAL_STOP_BITS": "1.5"}, nil, true},
+	}
+
+	for i, test := range tests {
+		setSerialEnvs(t, test.envs)
+
+		c, err := NewSerialConfig()
+		if test.err {
+			if err == nil {
+				t.Errorf("[%d] expected error, but nil", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("[%d] unexpected error: %v", i, err)
+			continue
+		}
+		if *c != *test.expected {
+			t.Errorf("[%d] expected %+v, but %+v", i, test.expected, c)
+		}
+	}
+}
+
+func TestAddSerialFlags(t *testing.T) {
+	setSerialEnvs(t, map[string]string{"SCUP_SERIAL_BAUD": "9600"})
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	AddSerialFlags(fs)
+	if err := fs.Parse([]string{"-port", "/dev/ttyACM0"}); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := NewSerialConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Name != "/dev/ttyACM0" || c.Baud != 9600 {
+		t.Errorf("expected /dev/ttyACM0 at 9600, but %s at %d", c.Name, c.Baud)
+	}
+}
@@ -0,0 +1,180 @@
+package environment
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+	"net"
+	"sync"
+	"time"
+)
+
+// VirtualPendulum is a software stand-in for the pendulum firmware. It speaks
+// the RRP protocol over any byte stream: it reads a RRPSendData request,
+// drives a simulated pendulum with the motor value and answers a
+// RRPEncodedReceiveData frame of the simulated sensors.
+//
+// The simulation is the Cartpole model with the cart position read as the
+// base angle. If Step is zero the simulation follows the wall clock,
+// otherwise it advances by Step per request so that runs are deterministic.
+type VirtualPendulum struct {
+	Step time.Duration
+
+	mu    sync.Mutex
+	cp    *Cartpole
+	clock time.Duration // simulated time since start
+	last  time.Time
+	motor float64
+}
+
+// virtualTransport is an in-process pipe to a VirtualPendulum.
+type virtualTransport struct {
+	net.Conn
+	peer net.Conn
+}
+
+// NewVirtualTransport returns a transport to vp served in a goroutine until
+// the transport is closed.
+func NewVirtualTransport(vp *VirtualPendulum) RRPTransport {
+	conn, peer := net.Pipe()
+	go vp.Serve(peer)
+	return &virtualTransport{conn, peer}
+}
+
+func (vt *virtualTransport) Close() error {
+	err := vt.Conn.Close()
+	vt.peer.Close()
+	return err
+}
+
+// virtualPendulumMaxDt is the longest time the simulation integrates at once.
+const virtualPendulumMaxDt = 5 * time.Millisecond
+
+func NewVirtualPendulum(step time.Duration) *VirtualPendulum {
+	cp := new(Cartpole)
+	cp.initModel()
+
+	return &VirtualPendulum{Step: step, cp: cp}
+}
+
+// Serve answers requests on rw until it is closed.
+func (vp *VirtualPendulum) Serve(rw io.ReadWriter) error {
+	req := make([]byte, RRPSendDataLen)
+
+	for {
+		if _, err := io.ReadFull(rw, req); err != nil {
+			if err == io.EOF || err == io.ErrClosedPipe {
+				return nil
+			}
+			return err
+		}
+
+		motor := math.Float64frombits(binary.BigEndian.Uint64(req))
+
+		if _, err := rw.Write(vp.Request(motor)); err != nil {
+			if err == io.ErrClosedPipe {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+// Request advances the simulation with the last motor value, sets motor and
+// returns the response frame.
+func (vp *VirtualPendulum) Request(motor float64) []byte {
+	vp.mu.Lock()
+	defer vp.mu.Unlock()
+
+	elapsed := vp.Step
+	if elapsed == 0 {
+		now := time.Now()
+		if !vp.last.IsZero() {
+			elapsed = now.Sub(vp.last)
+		}
+		vp.last = now
+	}
+	vp.advance(elapsed)
+
+	if math.IsNaN(motor) {
+		motor = 0
+	}
+	vp.motor = math.Max(-RRPMaxAbsAction, math.Min(RRPMaxAbsAction, motor))
+
+	return vp.frame()
+}
+
+func (vp *VirtualPendulum) advance(d time.Duration) {
+	vp.clock += d
+	for d > 0 {
+		dt := d
+		if dt > virtualPendulumMaxDt {
+			dt = virtualPendulumMaxDt
+		}
+		vp.cp.s = vp.cp.solveRungeKutta(vp.cp.s, vp.motor, dt.Seconds())
+		d -= dt
+	}
+}
+
+// State returns the simulated [base angle, pendulum angle, base velocity,
+// pendulum velocity] where the pendulum angle is zero at the top.
+func (vp *VirtualPendulum) State() []float64 {
+	vp.mu.Lock()
+	defer vp.mu.Unlock()
+
+	s := vp.cp.s
+	return s[:]
+}
+
+// frame encodes the sensors as the firmware does. The potentiometer reads
+// zero at the bottom.
+func (vp *VirtualPendulum) frame() []byte {
+	s := vp.cp.s
+
+	timeStamp := uint32(vp.clock/time.Millisecond) & (1<<24 - 1)
+	baseAngle := signedToRaw(int64(math.Round(s[0]/math.Pi*(RRPMaxEncoder/2))), RRPMaxEncoder)
+	pendulumAngle := signedToRaw(
+		int64(math.Round(vp.cp.normalize(s[1]-math.Pi)/math.Pi*(RRPMaxPotentiomater/2))), RRPMaxPotentiomater)
+	pwmDuty := voltageToRawPWMDuty(vp.motor * RRPMaxPWMVoltage)
+
+	buf := make([]byte, 0, RRPEncodedReceiveDataLen)
+	buf = appendEncoded(buf, timeStamp, 4)
+	buf = appendEncoded(buf, baseAngle, 3)
+	buf = appendEncoded(buf, pendulumAngle, 2)
+	buf = appendEncoded(buf, pwmDuty, 3)
+	buf = append(buf, calcCheckSum(buf), '\n')
+
+	return buf
+}
+
+// signedToRaw is the inverse of RRPReceiveData.rawToSigned.
+func signedToRaw(signed, max int64) uint32 {
+	half := max / 2
+	if signed >= half {
+		signed = half - 1
+	} else if signed < -half {
+		signed = -half
+	}
+	if signed < 0 {
+		signed += max
+	}
+	return uint32(signed)
+}
+
+// voltageToRawPWMDuty is the inverse of RRPReceiveData.rawPWMDutyToVoltage.
+func voltageToRawPWMDuty(v float64) uint32 {
+	var sign uint32
+	if v < 0 {
+		sign = 1
+	}
+	ratio := math.Min(math.Abs(v)/RRPMaxPWMVoltage, 1)
+	return sign<<16 | uint32(math.Round(RRPMaxPWMDuty*(1-ratio)))
+}
+
+// appendEncoded appends v as n 6-bit characters offset by 0x30.
+func appendEncoded(buf []byte, v uint32, n int) []byte {
+	for i := n - 1; i >= 0; i-- {
+		buf = append(buf, byte(v>>(6*uint(i))&0x3f)+0x30)
+	}
+	return buf
+}
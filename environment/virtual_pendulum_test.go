@@ -0,0 +1,92 @@
+package environment
+
+import (
+	"math"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestVirtualPendulumFrame(t *testing.T) {
+	tests := []struct {
+		state [4]float64
+		motor float64
+	}{
+		{[4]float64{0, math.Pi, 0, 0}, 0},
+		{[4]float64{0.5, math.Pi / 2, 0, 0}, 0.35},
+		{[4]float64{-1.2, -2.5, 0, 0}, -1},
+	}
+
+	for i, test := range tests {
+		vp := NewVirtualPendulum(10 * time.Millisecond)
+		vp.cp.s = test.state
+		vp.motor = test.motor
+
+		encData, err := NewRRPEncodedReceiveData(vp.frame())
+		if err != nil {
+			t.Fatalf("[%d] invalid frame: %v", i, err)
+		}
+		rsvData, err := encData.ToRRPReceiveData()
+		if err != nil {
+			t.Fatalf("[%d] invalid frame: %v", i, err)
+		}
+		s := rsvData.ToRRPState()
+
+		if math.Abs(s.BaseAngle-test.state[0]) > 1e-4 {
+			t.Errorf("[%d] expected base angle %v, but %v", i, test.state[0], s.BaseAngle)
+		}
+		pendulumAngle := relativeAngle(s.PendulumAngle, test.state[1]-math.Pi)
+		if math.Abs(pendulumAngle) > 2*math.Pi/RRPMaxPotentiomater {
+			t.Errorf("[%d] expected pendulum angle %v, but %v", i, test.state[1]-math.Pi, s.PendulumAngle)
+		}
+		if voltage := test.motor * RRPMaxPWMVoltage; math.Abs(s.PWMVoltage-voltage) > 1e-3 {
+			t.Errorf("[%d] expected voltage %v, but %v", i, voltage, s.PWMVoltage)
+		}
+	}
+}
+
+func TestRealRotatyPendulumOnVirtualPendulum(t *testing.T) {
+	for k, v := range map[string]string{
+		"SCUP_RRP_DT":           "1",
+		"SCUP_RRP_GOOD_REWARD":  "1000",
+		"SCUP_RRP_BAD_REWARD":   "-1000",
+		"SCUP_RRP_MAX_LATENESS": "1000",
+	} {
+		os.Setenv(k, v)
+		defer os.Unsetenv(k)
+	}
+
+	vp := NewVirtualPendulum(50 * time.Millisecond)
+	rrp := NewRealRotatyPendulum(NewVirtualTransport(vp))
+	if err := rrp.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := rrp.State()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, v := range s {
+		if math.Abs(v) > 1e-3 {
+			t.Errorf("expected resting state, but s[%d] = %v", i, v)
+		}
+	}
+
+	for i := 0; i < 10; i++ {
+		if err := rrp.RunStep([]float64{0.5}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s, err = rrp.State()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := vp.State()[0]; s[0] <= 0 || math.Abs(s[0]-expected) > 1e-4 {
+		t.Errorf("expected base angle %v, but %v", expected, s[0])
+	}
+
+	if err := rrp.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
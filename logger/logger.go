@@ -1,74 +1,254 @@
 package logger
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
-	"log"
+	"io"
 	"os"
+	"strconv"
 	"sync"
+	"time"
 )
 
 const (
-	Info = iota
+	Debug = iota
+	Info
 	Warn
 	Error
 	Fatal
 	Panic
 )
 
+var levelNames = []string{"DEBUG", "INFO", "WARN", "ERROR", "FATAL", "PANIC"}
+
+// ParseLevel parses a level name such as "INFO".
+func ParseLevel(s string) (int, error) {
+	for i, name := range levelNames {
+		if s == name {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("logger invalid log level: %s", s)
+}
+
 var once sync.Once = sync.Once{}
-var logger *Logger
+var std *output
 
-type Logger struct {
+// output is shared by a Logger and the loggers derived from it.
+type output struct {
+	mu    sync.Mutex
 	level int
-	l     *log.Logger
+	json  bool
+	w     io.Writer
+}
+
+// Logger is a leveled logger with a component prefix and key/value fields.
+// The zero Logger writes to the output configured by the env:
+//
+//	SCUP_LOG_LEVEL           DEBUG, INFO (default), WARN, ERROR, FATAL or PANIC
+//	SCUP_LOG_FORMAT          text (default) or json
+//	SCUP_LOG_FILE            output file (default stdout)
+//	SCUP_LOG_FILE_MAX_BYTES  rotate the file when it exceeds the size (default 0, never)
+//	SCUP_LOG_FILE_KEEP       number of rotated files to keep (default 3)
+//
+// The env is read on the first write, so that loggers may be made before the
+// env file is loaded.
+type Logger struct {
+	out       *output
+	component string
+	fields    []interface{}
 }
 
+// Get returns the root logger.
 func Get() *Logger {
+	return &Logger{}
+}
+
+// Component returns the root logger with the component prefix name.
+func Component(name string) *Logger {
+	return &Logger{component: name}
+}
+
+// New returns a logger writing to w at level.
+func New(w io.Writer, level int, json bool) *Logger {
+	return &Logger{out: &output{level: level, json: json, w: w}}
+}
+
+func defaultOutput() *output {
 	once.Do(func() {
-		level := Panic
-		levelStr, ok := os.LookupEnv("SCUP_LOG_LEVEL")
-		if ok {
-			switch levelStr {
-			case "INFO":
-				level = Info
-			case "WARN":
-				level = Warn
-			case "ERROR":
-				level = Error
-			case "FATAL":
-				level = Fatal
-			case "PANIC":
-				level = Fatal
-			default:
-				panic(fmt.Sprintf("logger invalid log level: %s", levelStr))
+		std = &output{level: Info, w: os.Stdout}
+
+		var errs []error
+
+		if s, ok := os.LookupEnv("SCUP_LOG_LEVEL"); ok {
+			level, err := ParseLevel(s)
+			if err != nil {
+				errs = append(errs, err)
+			} else {
+				std.level = level
+			}
+		}
+
+		switch format := os.Getenv("SCUP_LOG_FORMAT"); format {
+		case "", "text":
+		case "json":
+			std.json = true
+		default:
+			errs = append(errs, fmt.Errorf("logger invalid log format: %s", format))
+		}
+
+		if path, ok := os.LookupEnv("SCUP_LOG_FILE"); ok && path != "" {
+			w, err := openRotateFile(path)
+			if err != nil {
+				errs = append(errs, err)
+			} else {
+				std.w = w
 			}
 		}
 
-		logger = &Logger{level: level, l: log.New(os.Stdout, "", log.LstdFlags)}
+		for _, err := range errs {
+			(&Logger{out: std, component: "logger"}).Warn("%v", err)
+		}
 	})
-	return logger
+	return std
 }
 
-func (l *Logger) Info(format string, v ...interface{}) {
-	if l.level <= Info {
-		l.l.Fatalf("%s: %s\n", "INFO:", fmt.Sprintf(format, v...))
+func (l *Logger) output() *output {
+	if l.out == nil {
+		return defaultOutput()
 	}
+	return l.out
+}
+
+// With returns a logger which adds key/value pairs kv to every entry.
+func (l *Logger) With(kv ...interface{}) *Logger {
+	fields := make([]interface{}, 0, len(l.fields)+len(kv))
+	fields = append(fields, l.fields...)
+	fields = append(fields, kv...)
+	return &Logger{out: l.out, component: l.component, fields: fields}
+}
+
+// Component returns a logger with the component prefix name.
+func (l *Logger) Component(name string) *Logger {
+	return &Logger{out: l.out, component: name, fields: l.fields}
+}
+
+// Enabled reports whether entries of level are written.
+func (l *Logger) Enabled(level int) bool {
+	return l.output().level <= level
+}
+
+func (l *Logger) Debug(format string, v ...interface{}) {
+	l.log(Debug, fmt.Sprintf(format, v...))
+}
+
+func (l *Logger) Info(format string, v ...interface{}) {
+	l.log(Info, fmt.Sprintf(format, v...))
 }
 
 func (l *Logger) Warn(format string, v ...interface{}) {
-	if l.level <= Warn {
-		l.l.Fatalf("%s: %s\n", "WARN:", fmt.Sprintf(format, v...))
-	}
+	l.log(Warn, fmt.Sprintf(format, v...))
 }
 
 func (l *Logger) Error(format string, v ...interface{}) {
-	if l.level <= Error {
-		l.l.Fatalf("%s: %s\n", "ERROR:", fmt.Sprintf(format, v...))
-	}
+	l.log(Error, fmt.Sprintf(format, v...))
 }
 
+// Fatal logs and exits with status 1.
 func (l *Logger) Fatal(format string, v ...interface{}) {
-	if l.level <= Fatal {
-		l.l.Fatalf("%s: %s\n", "FATAL:", fmt.Sprintf(format, v...))
+	l.log(Fatal, fmt.Sprintf(format, v...))
+	os.Exit(1)
+}
+
+// Panic logs and panics.
+func (l *Logger) Panic(format string, v ...interface{}) {
+	msg := fmt.Sprintf(format, v...)
+	l.log(Panic, msg)
+	panic(msg)
+}
+
+func (l *Logger) log(level int, msg string) {
+	out := l.output()
+	if level < out.level {
+		return
+	}
+
+	now := time.Now()
+
+	buf := bytes.NewBuffer(nil)
+	if out.json {
+		l.formatJSON(buf, now, level, msg)
+	} else {
+		l.formatText(buf, now, level, msg)
+	}
+
+	out.mu.Lock()
+	defer out.mu.Unlock()
+	out.w.Write(buf.Bytes())
+}
+
+func (l *Logger) formatText(buf *bytes.Buffer, now time.Time, level int, msg string) {
+	buf.WriteString(now.Format("2006/01/02 15:04:05"))
+	buf.WriteByte(' ')
+	buf.WriteString(levelNames[level])
+	buf.WriteByte(' ')
+	if l.component != "" {
+		buf.WriteString("[" + l.component + "] ")
+	}
+	buf.WriteString(msg)
+
+	for i := 0; i < len(l.fields); i += 2 {
+		buf.WriteByte(' ')
+		buf.WriteString(fieldKey(l.fields, i))
+		buf.WriteByte('=')
+		if i+1 < len(l.fields) {
+			s := fmt.Sprint(l.fields[i+1])
+			if s == "" || bytes.ContainsAny([]byte(s), " \t\n\"=") {
+				s = strconv.Quote(s)
+			}
+			buf.WriteString(s)
+		}
+	}
+
+	buf.WriteByte('\n')
+}
+
+func (l *Logger) formatJSON(buf *bytes.Buffer, now time.Time, level int, msg string) {
+	writeKV := func(k string, v interface{}) {
+		kb, _ := json.Marshal(k)
+		vb, err := json.Marshal(v)
+		if err != nil {
+			vb, _ = json.Marshal(fmt.Sprint(v))
+		}
+		buf.WriteByte(',')
+		buf.Write(kb)
+		buf.WriteByte(':')
+		buf.Write(vb)
+	}
+
+	buf.WriteByte('{')
+	tb, _ := json.Marshal(now.Format(time.RFC3339Nano))
+	buf.WriteString(`"time":`)
+	buf.Write(tb)
+	writeKV("level", levelNames[level])
+	if l.component != "" {
+		writeKV("component", l.component)
+	}
+	writeKV("msg", msg)
+	for i := 0; i < len(l.fields); i += 2 {
+		var v interface{}
+		if i+1 < len(l.fields) {
+			v = l.fields[i+1]
+		}
+		writeKV(fieldKey(l.fields, i), v)
+	}
+	buf.WriteString("}\n")
+}
+
+func fieldKey(fields []interface{}, i int) string {
+	if k, ok := fields[i].(string); ok {
+		return k
 	}
+	return fmt.Sprint(fields[i])
 }
@@ -0,0 +1,120 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseLevel(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int
+	}{
+		{"DEBUG", Debug},
+		{"INFO", Info},
+		{"WARN", Warn},
+		{"ERROR", Error},
+		{"FATAL", Fatal},
+		{"PANIC", Panic},
+	}
+
+	for _, test := range tests {
+		level, err := ParseLevel(test.input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if level != test.expected {
+			t.Errorf("[%s] expected %d, but %d", test.input, test.expected, level)
+		}
+	}
+
+	if _, err := ParseLevel("VERBOSE"); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestLoggerText(t *testing.T) {
+	buf := new(bytes.Buffer)
+	l := New(buf, Warn, false).Component("agent").With("episode", 3)
+
+	l.Info("hidden")
+	l.Warn("saved to %s", "a.gob")
+	l.With("path", "a b").Error("failed")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 lines, but %q", buf.String())
+	}
+	if expected := "WARN [agent] saved to a.gob episode=3"; !strings.HasSuffix(lines[0], expected) {
+		t.Errorf("expected suffix %q, but %q", expected, lines[0])
+	}
+	if expected := `ERROR [agent] failed episode=3 path="a b"`; !strings.HasSuffix(lines[1], expected) {
+		t.Errorf("expected suffix %q, but %q", expected, lines[1])
+	}
+}
+
+func TestLoggerJSON(t *testing.T) {
+	buf := new(bytes.Buffer)
+	New(buf, Debug, true).Component("rl").With("episode", 3, "returns", 1.5).Debug("end %s", "up")
+
+	var entry map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("invalid json %q: %v", buf.String(), err)
+	}
+
+	expected := map[string]interface{}{
+		"level":     "DEBUG",
+		"component": "rl",
+		"msg":       "end up",
+		"episode":   3.,
+		"returns":   1.5,
+	}
+	for k, v := range expected {
+		if entry[k] != v {
+			t.Errorf("[%s] expected %v, but %v", k, v, entry[k])
+		}
+	}
+}
+
+func TestRotateFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "scup.log")
+
+	rf, err := newRotateFile(path, 10, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"aaaaaa\n", "bbbbbb\n", "cccccc\n", "dddddd\n"} {
+		if _, err := rf.Write([]byte(s)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := rf.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]string{
+		path:                 "dddddd\n",
+		rotatedPath(path, 1): "cccccc\n",
+		rotatedPath(path, 2): "bbbbbb\n",
+		rotatedPath(path, 3): "",
+	}
+	for p, s := range expected {
+		b, err := ioutil.ReadFile(p)
+		if s == "" {
+			if err == nil {
+				t.Errorf("expected %s removed", p)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != s {
+			t.Errorf("[%s] expected %q, but %q", p, s, b)
+		}
+	}
+}
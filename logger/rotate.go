@@ -0,0 +1,101 @@
+package logger
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/high-moctane/lab_scup2020/utils"
+)
+
+// rotateFile is a log file which is renamed to path.1, path.2, ... when it
+// exceeds maxBytes. Only keep rotated files remain.
+type rotateFile struct {
+	mu       sync.Mutex
+	path     string
+	maxBytes int64
+	keep     int
+	f        *os.File
+	size     int64
+}
+
+func openRotateFile(path string) (*rotateFile, error) {
+	maxBytes, err := utils.GetEnvIntDefault("SCUP_LOG_FILE_MAX_BYTES", 0)
+	if err != nil {
+		return nil, err
+	}
+	keep, err := utils.GetEnvIntDefault("SCUP_LOG_FILE_KEEP", 3)
+	if err != nil {
+		return nil, err
+	}
+	return newRotateFile(path, int64(maxBytes), keep)
+}
+
+func newRotateFile(path string, maxBytes int64, keep int) (*rotateFile, error) {
+	res := &rotateFile{path: path, maxBytes: maxBytes, keep: keep}
+	if err := res.open(); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (rf *rotateFile) open() error {
+	f, err := os.OpenFile(rf.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("logger cannot open %s: %w", rf.path, err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("logger cannot open %s: %w", rf.path, err)
+	}
+	rf.f = f
+	rf.size = info.Size()
+	return nil
+}
+
+func (rf *rotateFile) Write(p []byte) (int, error) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+
+	if rf.maxBytes > 0 && rf.size > 0 && rf.size+int64(len(p)) > rf.maxBytes {
+		if err := rf.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := rf.f.Write(p)
+	rf.size += int64(n)
+	return n, err
+}
+
+func (rf *rotateFile) rotate() error {
+	if err := rf.f.Close(); err != nil {
+		return err
+	}
+
+	if rf.keep > 0 {
+		os.Remove(rotatedPath(rf.path, rf.keep))
+		for i := rf.keep - 1; i >= 1; i-- {
+			os.Rename(rotatedPath(rf.path, i), rotatedPath(rf.path, i+1))
+		}
+		if err := os.Rename(rf.path, rotatedPath(rf.path, 1)); err != nil {
+			return err
+		}
+	} else if err := os.Remove(rf.path); err != nil {
+		return err
+	}
+
+	return rf.open()
+}
+
+func (rf *rotateFile) Close() error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	return rf.f.Close()
+}
+
+func rotatedPath(path string, i int) string {
+	return path + "." + strconv.Itoa(i)
+}
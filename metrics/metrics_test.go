@@ -0,0 +1,60 @@
+package metrics
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRegistryWriteText(t *testing.T) {
+	r := NewRegistry()
+
+	r.Counter("scup_episodes_total", "Episodes.", "agent", "up").Inc()
+	r.Counter("scup_episodes_total", "Episodes.", "agent", "up").Add(2)
+	r.Counter("scup_episodes_total", "Episodes.", "agent", "down").Inc()
+	r.Gauge("scup_epsilon", "Epsilon.").Set(0.1)
+	h := r.Histogram("scup_step_seconds", "Step.", []float64{0.01, 0.1}, "agent", "up")
+	h.Observe(0.005)
+	h.Observe(0.05)
+	h.Observe(1)
+
+	buf := new(bytes.Buffer)
+	if err := r.WriteText(buf); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `# HELP scup_episodes_total Episodes.
+# TYPE scup_episodes_total counter
+scup_episodes_total{agent="down"} 1
+scup_episodes_total{agent="up"} 3
+# HELP scup_epsilon Epsilon.
+# TYPE scup_epsilon gauge
+scup_epsilon 0.1
+# HELP scup_step_seconds Step.
+# TYPE scup_step_seconds histogram
+scup_step_seconds_bucket{agent="up",le="0.01"} 1
+scup_step_seconds_bucket{agent="up",le="0.1"} 2
+scup_step_seconds_bucket{agent="up",le="+Inf"} 3
+scup_step_seconds_sum{agent="up"} 1.055
+scup_step_seconds_count{agent="up"} 3
+`
+	if buf.String() != expected {
+		t.Errorf("expected\n%s\nbut\n%s", expected, buf.String())
+	}
+}
+
+func TestRegistryHandler(t *testing.T) {
+	r := NewRegistry()
+	r.Gauge("scup_return_last", "Return.", "agent", "up").Set(-3.5)
+
+	rec := httptest.NewRecorder()
+	r.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Errorf("unexpected content type %s", ct)
+	}
+	if !strings.Contains(rec.Body.String(), `scup_return_last{agent="up"} -3.5`) {
+		t.Errorf("unexpected body %s", rec.Body.String())
+	}
+}
@@ -0,0 +1,48 @@
+package lab_scup2020
+
+import (
+	"time"
+
+	"github.com/high-moctane/lab_scup2020/metrics"
+)
+
+// rlMetrics is the metrics of an agent.
+type rlMetrics struct {
+	episodes, evalEpisodes *metrics.Counter
+
+	lastReturn, meanReturn, lastSteps *metrics.Gauge
+	epsilon, agentEpisodes            *metrics.Gauge
+
+	loop, envStep, agentStep *metrics.Histogram
+}
+
+var rlMetricsUp, rlMetricsDown = newRLMetrics("up"), newRLMetrics("down")
+
+func newRLMetrics(agent string) *rlMetrics {
+	return &rlMetrics{
+		episodes:      metrics.NewCounter("scup_episodes_total", "Training episodes completed.", "agent", agent),
+		evalEpisodes:  metrics.NewCounter("scup_eval_episodes_total", "Evaluation episodes completed.", "agent", agent),
+		lastReturn:    metrics.NewGauge("scup_return_last", "Return of the last training episode.", "agent", agent),
+		meanReturn:    metrics.NewGauge("scup_return_mean", "Mean return of the training episodes of the run.", "agent", agent),
+		lastSteps:     metrics.NewGauge("scup_steps_last", "Steps of the last training episode.", "agent", agent),
+		epsilon:       metrics.NewGauge("scup_epsilon", "Current epsilon of the agent.", "agent", agent),
+		agentEpisodes: metrics.NewGauge("scup_agent_episodes", "Episodes the agent has learned.", "agent", agent),
+		loop: metrics.NewHistogram("scup_control_loop_seconds", "Period of the control loop.",
+			metrics.DefBuckets, "agent", agent),
+		envStep: metrics.NewHistogram("scup_env_step_seconds", "Latency of running a step and reading the state.",
+			metrics.DefBuckets, "agent", agent),
+		agentStep: metrics.NewHistogram("scup_agent_step_seconds", "Latency of choosing an action and learning.",
+			metrics.DefBuckets, "agent", agent),
+	}
+}
+
+func modeMetrics(mode int) *rlMetrics {
+	if mode == RLRunDown {
+		return rlMetricsDown
+	}
+	return rlMetricsUp
+}
+
+func observeSince(h *metrics.Histogram, start time.Time) {
+	h.Observe(time.Since(start).Seconds())
+}
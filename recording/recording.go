@@ -0,0 +1,107 @@
+package recording
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/gob"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/high-moctane/lab_scup2020/utils"
+)
+
+const recordingVersion = 1
+
+// Episode is the trajectory of an episode. Raw frames are kept when the
+// environment reads them from a device so that the states can be decoded
+// again.
+type Episode struct {
+	Version int
+
+	Env     string
+	Agent   string
+	Episode int
+	Time    time.Time
+
+	InitialState  []float64
+	InitialReward float64
+	InitialRaw    []byte
+
+	Steps []Step
+}
+
+// Step is a step of an episode. State is the state after Action is taken
+// and Reward is the reward of State.
+//
+// A Restart step is one which overran its control period. Its State is where
+// the episode goes on from, but it is not a transition to learn and its
+// Reward is not in the return.
+type Step struct {
+	Action  []float64
+	State   []float64
+	Reward  float64
+	Finish  bool
+	Restart bool
+	Raw     []byte
+}
+
+func New(env, agent string, episode int) *Episode {
+	return &Episode{
+		Version: recordingVersion,
+		Env:     env,
+		Agent:   agent,
+		Episode: episode,
+		Time:    time.Now(),
+	}
+}
+
+// Path returns the path of the recording of episode of agent in dir, e.g.
+// dir/up_ep000123.rec.gz.
+func Path(dir, agent string, episode int) string {
+	return filepath.Join(dir, fmt.Sprintf("%s_ep%06d.rec.gz", agent, episode))
+}
+
+// Save writes ep to dst as a gzipped gob atomically.
+func Save(dst string, ep *Episode) error {
+	buf := bytes.NewBuffer(nil)
+
+	zw := gzip.NewWriter(buf)
+	if err := gob.NewEncoder(zw).Encode(ep); err != nil {
+		return fmt.Errorf("cannot save recording to %s: %w", dst, err)
+	}
+	if err := zw.Close(); err != nil {
+		return fmt.Errorf("cannot save recording to %s: %w", dst, err)
+	}
+
+	if err := utils.WriteFileAtomic(dst, buf.Bytes()); err != nil {
+		return fmt.Errorf("cannot save recording to %s: %w", dst, err)
+	}
+	return nil
+}
+
+// Load reads the recording at src.
+func Load(src string) (*Episode, error) {
+	f, err := os.Open(src)
+	if err != nil {
+		return nil, fmt.Errorf("cannot load recording from %s: %w", src, err)
+	}
+	defer f.Close()
+
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("cannot load recording from %s: %w", src, err)
+	}
+	defer zr.Close()
+
+	var ep Episode
+	if err := gob.NewDecoder(zr).Decode(&ep); err != nil {
+		return nil, fmt.Errorf("cannot load recording from %s: %w", src, err)
+	}
+	if ep.Version > recordingVersion {
+		return nil, fmt.Errorf("cannot load recording from %s: unknown version %d", src, ep.Version)
+	}
+
+	return &ep, nil
+}
@@ -0,0 +1,39 @@
+package recording
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSaveLoad(t *testing.T) {
+	ep := New("RealRotatyPendulum", "up", 12)
+	ep.InitialState = []float64{0, 1, 2, 3}
+	ep.InitialReward = -0.5
+	ep.InitialRaw = []byte("0123456789abc\n")
+	ep.Steps = []Step{
+		{[]float64{1}, []float64{0.1, 1, 2, 3}, -0.4, false, false, []byte("1123456789abc\n")},
+		{[]float64{1}, []float64{0.3, 1, 2, 3}, -0.3, false, true, nil},
+		{[]float64{-1}, []float64{0.2, 1, 2, 3}, -1000, true, false, nil},
+	}
+
+	dir := t.TempDir()
+	dst := Path(dir, ep.Agent, ep.Episode)
+	if expected := filepath.Join(dir, "up_ep000012.rec.gz"); dst != expected {
+		t.Errorf("expected %s, but %s", expected, dst)
+	}
+
+	if err := Save(dst, ep); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := Load(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// gob does not keep the monotonic clock.
+	loaded.Time = ep.Time
+	if !reflect.DeepEqual(loaded, ep) {
+		t.Errorf("expected %+v, but %+v", ep, loaded)
+	}
+}
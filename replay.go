@@ -0,0 +1,78 @@
+package lab_scup2020
+
+import (
+	"fmt"
+
+	"github.com/high-moctane/lab_scup2020/agent"
+	"github.com/high-moctane/lab_scup2020/recording"
+)
+
+// ReplayLearn makes ag learn the recorded episode ep offline in the same
+// order RL.RunEpisode would have. The on-policy next action is the recorded
+// one, except for the last step where ag chooses it. Restart steps are not
+// learned. It returns the return of ep counted as RL.RunEpisode does.
+//
+// If ag acts from a fixed action set, every recorded action must be in it.
+func ReplayLearn(ag agent.Agent, ep *recording.Episode) (float64, error) {
+	if da, ok := ag.(agent.DiscreteAgent); ok {
+		if err := checkReplayActions(da.Actions(), ep); err != nil {
+			return 0, err
+		}
+	}
+
+	ag.Reset()
+
+	returns := ep.InitialReward
+	if len(ep.Steps) == 0 {
+		return returns, nil
+	}
+
+	s1, a1 := ep.InitialState, ep.Steps[0].Action
+
+	for i, step := range ep.Steps {
+		if step.Restart {
+			// The episode goes on from here without learning the overrun.
+			if i+1 == len(ep.Steps) {
+				break
+			}
+			s1, a1 = step.State, ep.Steps[i+1].Action
+			continue
+		}
+
+		var a2 []float64
+		if i+1 < len(ep.Steps) {
+			a2 = ep.Steps[i+1].Action
+		} else {
+			a2 = ag.Action(step.State)
+		}
+
+		ag.Learn(s1, a1, step.Reward, step.State, a2)
+
+		// RL.RunEpisode stops after the step following a finish.
+		if i > 0 && ep.Steps[i-1].Finish {
+			break
+		}
+
+		s1, a1 = step.State, a2
+		returns += step.Reward
+	}
+
+	return returns, nil
+}
+
+// checkReplayActions returns an error if ep has an action not in actions,
+// e.g. when it was recorded with another SCUP_AGENT_ACTION.
+func checkReplayActions(actions [][]float64, ep *recording.Episode) error {
+	known := map[string]bool{}
+	for _, a := range actions {
+		known[fmt.Sprint(a)] = true
+	}
+
+	for i, step := range ep.Steps {
+		if !known[fmt.Sprint(step.Action)] {
+			return fmt.Errorf("cannot replay %s episode %d: action %v of step %d is not in %v",
+				ep.Agent, ep.Episode, step.Action, i, actions)
+		}
+	}
+	return nil
+}
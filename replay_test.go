@@ -0,0 +1,107 @@
+package lab_scup2020
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/high-moctane/lab_scup2020/agent"
+	"github.com/high-moctane/lab_scup2020/recording"
+)
+
+func TestReplayLearn(t *testing.T) {
+	dir := t.TempDir()
+	env := testRLEnv(dir)
+	env["SCUP_RL_RECORD_DIR"] = filepath.Join(dir, "recordings")
+	setTestEnv(t, env)
+
+	rl := newTestRL(t)
+
+	// A copy of the agent before the episode learns the recording.
+	before := filepath.Join(dir, "before.gob")
+	if err := rl.agentUp.Save(before); err != nil {
+		t.Fatal(err)
+	}
+	replayed := new(agent.QLearning)
+	if err := replayed.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if err := replayed.Load(before); err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := rl.RunEpisodeUp(context.Background(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ep, err := recording.Load(recording.Path(env["SCUP_RL_RECORD_DIR"], "up", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	returns, err := ReplayLearn(replayed, ep)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if returns != res.Returns {
+		t.Errorf("expected returns %v, but %v", res.Returns, returns)
+	}
+	// Q-learning bootstraps from the max, so the action ReplayLearn picks for
+	// the last step does not change the table.
+	if live := rl.agentUp.(*agent.QLearning).QTable; !reflect.DeepEqual(replayed.QTable, live) {
+		t.Error("expected the replayed table to match the live one")
+	}
+
+	// A recording with actions the agent does not have is rejected.
+	setTestEnv(t, map[string]string{"SCUP_AGENT_ACTION": "-0.5:0.5"})
+	other := new(agent.QLearning)
+	if err := other.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReplayLearn(other, ep); err == nil {
+		t.Error("expected an error for unknown actions")
+	}
+}
+
+// transitionAgent remembers the transitions it learns.
+type transitionAgent struct {
+	learned [][2]float64
+}
+
+func (ta *transitionAgent) Init() error                  { return nil }
+func (ta *transitionAgent) Reset()                       {}
+func (ta *transitionAgent) Action(s []float64) []float64 { return []float64{0} }
+func (ta *transitionAgent) Save(string) error            { return nil }
+func (ta *transitionAgent) Load(string) error            { return nil }
+
+func (ta *transitionAgent) Learn(s1, a1 []float64, r float64, s2, a2 []float64) {
+	ta.learned = append(ta.learned, [2]float64{s1[0], s2[0]})
+}
+
+func TestReplayLearn_restart(t *testing.T) {
+	ep := recording.New("Cartpole", "up", 0)
+	ep.InitialState = []float64{0}
+	ep.InitialReward = 1
+	ep.Steps = []recording.Step{
+		{Action: []float64{1}, State: []float64{1}, Reward: 1},
+		{Action: []float64{1}, State: []float64{5}, Reward: 1, Restart: true},
+		{Action: []float64{-1}, State: []float64{6}, Reward: 1},
+		{Action: []float64{-1}, State: []float64{7}, Reward: 1},
+	}
+
+	ag := new(transitionAgent)
+	returns, err := ReplayLearn(ag, ep)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The overrun from 1 to 5 is not learned and its reward is not counted.
+	if expected := [][2]float64{{0, 1}, {5, 6}, {6, 7}}; !reflect.DeepEqual(ag.learned, expected) {
+		t.Errorf("expected transitions %v, but %v", expected, ag.learned)
+	}
+	if returns != 4 {
+		t.Errorf("expected returns 4, but %v", returns)
+	}
+}
@@ -4,12 +4,19 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"log"
+	"io/ioutil"
+	"math"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/high-moctane/lab_scup2020/agent"
 	"github.com/high-moctane/lab_scup2020/environment"
-	_ "github.com/high-moctane/lab_scup2020/logger"
+	"github.com/high-moctane/lab_scup2020/logger"
+	"github.com/high-moctane/lab_scup2020/recording"
+	"github.com/high-moctane/lab_scup2020/telemetry"
 	"github.com/high-moctane/lab_scup2020/utils"
 )
 
@@ -17,10 +24,15 @@ const (
 	RLRunUpDown = iota
 	RLRunUp
 	RLRunDown
+	RLRunEvalUpDown
+	RLRunEvalUp
+	RLRunEvalDown
 )
 
 var EndOfEpisode = errors.New("end of episode")
 
+var lg = logger.Component("rl")
+
 type RL struct {
 	env environment.Environment
 
@@ -32,14 +44,74 @@ type RL struct {
 
 	maxEpisode             int
 	maxStepUp, maxStepDown int
+	evalEpisode            int
+
+	evalFreq int
+
+	seed         int64
+	runStatePath string
+	startEpisode int
+	stats        RunStats
+
+	telemetry *telemetry.Recorder
+	observers []Observer
+
+	envName   string
+	recordDir string
+}
+
+// Observer receives the steps and episodes RL runs, e.g. to show them live.
+// It is called from the RL goroutine and must not block.
+type Observer interface {
+	OnStep(rec *telemetry.StepRecord)
+	OnEpisode(rec *telemetry.EpisodeRecord)
+}
+
+// seedRandomized seeds v if it has its own random source.
+func seedRandomized(v interface{}, seed int64) {
+	if r, ok := v.(utils.Randomized); ok {
+		r.Seed(seed)
+	}
+}
+
+// EpisodeResult is the outcome of an episode. Finished reports whether
+// IsFinishUp or IsFinishDown triggered. Termination is one of the telemetry
+// termination reasons.
+type EpisodeResult struct {
+	Returns     float64
+	Steps       int
+	Finished    bool
+	Termination string
+}
+
+// EvalResult summarizes evaluation episodes.
+type EvalResult struct {
+	Episodes              int
+	MeanReturn, StdReturn float64
+	SuccessRate           float64
+	MeanSteps             float64
+	MinSteps, MaxSteps    int
 }
 
 func NewRL() (*RL, error) {
+	// Seed
+	seed := time.Now().UnixNano()
+	if _, ok := os.LookupEnv("SCUP_SEED"); ok {
+		var err error
+		seed, err = utils.GetEnvInt64("SCUP_SEED")
+		if err != nil {
+			return nil, fmt.Errorf("new rl failed: %w", err)
+		}
+	}
+	lg.Info("seed %d", seed)
+	seeds := utils.SplitSeed(seed, 3)
+
 	// Env
 	env, err := environment.SelectEnvironment()
 	if err != nil {
 		return nil, fmt.Errorf("new rl failed: %w", err)
 	}
+	seedRandomized(env, seeds[0])
 	if err := env.Init(); err != nil {
 		return nil, fmt.Errorf("new rl failed: %w", err)
 	}
@@ -49,6 +121,7 @@ func NewRL() (*RL, error) {
 	if err != nil {
 		return nil, fmt.Errorf("new rl failed: %w", err)
 	}
+	seedRandomized(agentUp, seeds[1])
 	if err := agentUp.Init(); err != nil {
 		return nil, fmt.Errorf("new agentup failed: %w", err)
 	}
@@ -57,10 +130,16 @@ func NewRL() (*RL, error) {
 	if err != nil {
 		return nil, fmt.Errorf("new rl failed: %w", err)
 	}
+	seedRandomized(agentDown, seeds[2])
 	if err := agentDown.Init(); err != nil {
 		return nil, fmt.Errorf("new agentup failed: %w", err)
 	}
 
+	// Both agents have the same action config.
+	if err := checkActionRange(env, agentUp); err != nil {
+		return nil, fmt.Errorf("new rl failed: %w", err)
+	}
+
 	// Reward func
 	rewardFuncUp := env.RewardFuncUp()
 	rewardFuncDown := env.RewardFuncDown()
@@ -110,6 +189,36 @@ func NewRL() (*RL, error) {
 		return nil, fmt.Errorf("new rl failed: %w", err)
 	}
 
+	// Evaluation episodes
+	evalEpisode, err := utils.GetEnvIntDefault("SCUP_RL_EVAL_EPISODE", 10)
+	if err != nil {
+		return nil, fmt.Errorf("new rl failed: %w", err)
+	}
+
+	// Periodic evaluation while training. 0 disables it.
+	evalFreq, err := utils.GetEnvIntDefault("SCUP_RL_EVAL_FREQUENT", 0)
+	if err != nil {
+		return nil, fmt.Errorf("new rl failed: %w", err)
+	}
+
+	// Run state
+	runStatePath := utils.GetEnvStringDefault("SCUP_RL_RUN_STATE_PATH", "")
+
+	// Telemetry
+	recorder, err := telemetry.New()
+	if err != nil {
+		return nil, fmt.Errorf("new rl failed: %w", err)
+	}
+
+	// Recording of training episodes. Empty disables it.
+	recordDir := utils.GetEnvStringDefault("SCUP_RL_RECORD_DIR", "")
+	if recordDir != "" {
+		if err := os.MkdirAll(recordDir, 0755); err != nil {
+			recorder.Close()
+			return nil, fmt.Errorf("new rl failed: %w", err)
+		}
+	}
+
 	res := &RL{
 		env,
 		agentUp,
@@ -124,13 +233,30 @@ func NewRL() (*RL, error) {
 		maxEpisode,
 		maxStepUp,
 		maxStepDown,
+		evalEpisode,
+		evalFreq,
+		seed,
+		runStatePath,
+		0,
+		newRunStats(),
+		recorder,
+		nil,
+		os.Getenv("SCUP_ENV_NAME"),
+		recordDir,
+	}
+
+	if runStatePath != "" {
+		if err := res.loadRunState(); err != nil {
+			recorder.Close()
+			return nil, fmt.Errorf("new rl failed: %w", err)
+		}
 	}
 
 	return res, nil
 }
 
 func (rl *RL) RunUpDown(ctx context.Context) error {
-	for episode := 0; rl.maxEpisode == -1 || episode < rl.maxEpisode; episode++ {
+	for episode := rl.startEpisode; rl.maxEpisode == -1 || episode < rl.maxEpisode; episode++ {
 		select {
 		case <-ctx.Done():
 			return nil
@@ -150,13 +276,24 @@ func (rl *RL) RunUpDown(ctx context.Context) error {
 				return fmt.Errorf("rl run error: %w", err)
 			}
 		}
+
+		if err := rl.evalPeriodically(ctx, episode, RLRunUp); err != nil {
+			return fmt.Errorf("rl run error: %w", err)
+		}
+		if err := rl.evalPeriodically(ctx, episode, RLRunDown); err != nil {
+			return fmt.Errorf("rl run error: %w", err)
+		}
+
+		if err := rl.saveRunState(episode); err != nil {
+			return fmt.Errorf("rl run error: %w", err)
+		}
 	}
 
 	return nil
 }
 
 func (rl *RL) RunUp(ctx context.Context) error {
-	for episode := 0; rl.maxEpisode == -1 || episode < rl.maxEpisode; episode++ {
+	for episode := rl.startEpisode; rl.maxEpisode == -1 || episode < rl.maxEpisode; episode++ {
 		select {
 		case <-ctx.Done():
 			return nil
@@ -170,13 +307,21 @@ func (rl *RL) RunUp(ctx context.Context) error {
 				return fmt.Errorf("rl run error: %w", err)
 			}
 		}
+
+		if err := rl.evalPeriodically(ctx, episode, RLRunUp); err != nil {
+			return fmt.Errorf("rl run error: %w", err)
+		}
+
+		if err := rl.saveRunState(episode); err != nil {
+			return fmt.Errorf("rl run error: %w", err)
+		}
 	}
 
 	return nil
 }
 
 func (rl *RL) RunDown(ctx context.Context) error {
-	for episode := 0; rl.maxEpisode == -1 || episode < rl.maxEpisode; episode++ {
+	for episode := rl.startEpisode; rl.maxEpisode == -1 || episode < rl.maxEpisode; episode++ {
 		select {
 		case <-ctx.Done():
 			return nil
@@ -189,6 +334,14 @@ func (rl *RL) RunDown(ctx context.Context) error {
 				return fmt.Errorf("rl run error: %w", err)
 			}
 		}
+
+		if err := rl.evalPeriodically(ctx, episode, RLRunDown); err != nil {
+			return fmt.Errorf("rl run error: %w", err)
+		}
+
+		if err := rl.saveRunState(episode); err != nil {
+			return fmt.Errorf("rl run error: %w", err)
+		}
 	}
 
 	return nil
@@ -202,26 +355,253 @@ func (rl *RL) Run(ctx context.Context, mode int) error {
 		return rl.RunUp(ctx)
 	case RLRunDown:
 		return rl.RunDown(ctx)
+	case RLRunEvalUpDown:
+		if _, err := rl.Evaluate(ctx, RLRunUp, rl.evalEpisode); err != nil {
+			return err
+		}
+		_, err := rl.Evaluate(ctx, RLRunDown, rl.evalEpisode)
+		return err
+	case RLRunEvalUp:
+		_, err := rl.Evaluate(ctx, RLRunUp, rl.evalEpisode)
+		return err
+	case RLRunEvalDown:
+		_, err := rl.Evaluate(ctx, RLRunDown, rl.evalEpisode)
+		return err
 	default:
 		return fmt.Errorf("rl run error: invalid mode: %d", mode)
 	}
 }
 
-func (rl *RL) RunEpisodeUp(ctx context.Context, episode int) (returns float64, err error) {
-	log.Printf("up start episode %d", episode)
-	returns, err = rl.RunEpisode(ctx, episode, RLRunUp)
-	log.Printf("up end episode %d reward %v", episode, returns)
+// Evaluate runs n episodes of mode (RLRunUp or RLRunDown) with exploration
+// and learning off. The agent file is never written. If ctx is done, res has
+// only the episodes which ran to the end.
+func (rl *RL) Evaluate(ctx context.Context, mode, n int) (res EvalResult, err error) {
+	var ag agent.Agent
+	var name string
+
+	switch mode {
+	case RLRunUp:
+		ag, name = rl.agentUp, "up"
+	case RLRunDown:
+		ag, name = rl.agentDown, "down"
+	default:
+		return res, fmt.Errorf("rl evaluate error: invalid mode: %d", mode)
+	}
+
+	if ev, ok := ag.(agent.Evaluator); ok {
+		ev.SetEval(true)
+		defer ev.SetEval(false)
+	} else {
+		lg.Warn("eval %s: agent cannot disable exploration", name)
+	}
+
+	results := []EpisodeResult{}
+
+	for episode := 0; episode < n; episode++ {
+		select {
+		case <-ctx.Done():
+			return summarizeEval(results), nil
+		default:
+		}
+
+		r, err := rl.RunEpisode(ctx, episode, mode, false)
+		if err != nil && !errors.Is(EndOfEpisode, err) {
+			return summarizeEval(results), fmt.Errorf("rl evaluate error: %w", err)
+		}
+		if r.Termination == telemetry.TerminationInterrupted {
+			// The return of a cut short episode is not comparable, so it
+			// is left out and res has fewer than n episodes.
+			lg.With("agent", name, "episode", episode).Info("eval interrupted")
+			return summarizeEval(results), nil
+		}
+		lg.With("agent", name, "episode", episode, "returns", r.Returns, "steps", r.Steps, "finished", r.Finished).
+			Info("eval episode")
+
+		results = append(results, r)
+	}
+
+	res = summarizeEval(results)
+	lg.With("agent", name, "episodes", res.Episodes, "mean_return", res.MeanReturn, "std_return", res.StdReturn,
+		"success_rate", res.SuccessRate, "mean_steps", res.MeanSteps, "min_steps", res.MinSteps, "max_steps", res.MaxSteps).
+		Info("eval summary")
+
+	return res, nil
+}
+
+// evalPeriodically evaluates the agent of mode every evalFreq episodes and
+// saves it to the best data path when the mean return is the best so far.
+func (rl *RL) evalPeriodically(ctx context.Context, episode, mode int) error {
+	if rl.evalFreq <= 0 || (episode+1)%rl.evalFreq != 0 {
+		return nil
+	}
+
+	res, err := rl.Evaluate(ctx, mode, rl.evalEpisode)
+	if err != nil {
+		return err
+	}
+	if res.Episodes < rl.evalEpisode {
+		// Interrupted
+		return nil
+	}
+
+	var ag agent.Agent
+	var best *float64
+	var dst string
+
+	switch mode {
+	case RLRunUp:
+		ag, best, dst = rl.agentUp, &rl.stats.BestReturnUp, BestDataPath(rl.agentUpDataPath)
+	case RLRunDown:
+		ag, best, dst = rl.agentDown, &rl.stats.BestReturnDown, BestDataPath(rl.agentDownDataPath)
+	}
+
+	if math.IsInf(*best, -1) {
+		// Without a run state, the best agent of an earlier run is only
+		// known by its saved return.
+		if *best, err = loadBestReturn(dst); err != nil {
+			return fmt.Errorf("cannot save best agent: %w", err)
+		}
+	}
+	if res.MeanReturn <= *best {
+		return nil
+	}
+
+	*best = res.MeanReturn
+	if err := agent.SaveUnnumbered(ag, dst); err != nil {
+		return fmt.Errorf("cannot save best agent: %w", err)
+	}
+	ret := strconv.FormatFloat(*best, 'g', -1, 64) + "\n"
+	if err := utils.WriteFileAtomic(bestReturnPath(dst), []byte(ret)); err != nil {
+		return fmt.Errorf("cannot save best agent: %w", err)
+	}
+	lg.Info("best agent returns %v at episode %d saved to %s", res.MeanReturn, episode, dst)
+
+	return nil
+}
+
+// checkActionRange returns an error if an action of ag is out of the range of
+// env, e.g. SCUP_AGENT_ACTION_MAX of DDPG over the motor range.
+func checkActionRange(env environment.Environment, ag agent.Agent) error {
+	ranger, ok := env.(environment.ActionRanger)
+	if !ok {
+		return nil
+	}
+	max := ranger.ActionMax()
+
+	var actions [][]float64
+	switch ag := ag.(type) {
+	case agent.ContinuousAgent:
+		actions = [][]float64{ag.ActionMax()}
+	case agent.DiscreteAgent:
+		actions = ag.Actions()
+	}
+
+	for _, a := range actions {
+		if len(a) != len(max) {
+			return fmt.Errorf("action %v must have %d dimensions", a, len(max))
+		}
+		for i := range a {
+			if math.Abs(a[i]) > max[i] {
+				return fmt.Errorf("action %v is out of the range %v of the environment", a, max)
+			}
+		}
+	}
+	return nil
+}
+
+// BestDataPath returns the path of the best agent file next to path, e.g.
+// agent_up.best.gob for agent_up.gob.
+func BestDataPath(path string) string {
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + ".best" + ext
+}
+
+// bestReturnPath returns the path of the return of the best agent file dst,
+// e.g. agent_up.best.return for agent_up.best.gob.
+func bestReturnPath(dst string) string {
+	return strings.TrimSuffix(dst, filepath.Ext(dst)) + ".return"
+}
+
+// loadBestReturn returns the mean return saved with the best agent file dst,
+// or -Inf if there is no best agent yet.
+func loadBestReturn(dst string) (float64, error) {
+	if _, err := os.Stat(dst); os.IsNotExist(err) {
+		return math.Inf(-1), nil
+	}
+
+	b, err := ioutil.ReadFile(bestReturnPath(dst))
+	if os.IsNotExist(err) {
+		lg.Warn("best agent %s has no saved return, it will be replaced by the next evaluation", dst)
+		return math.Inf(-1), nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("cannot load best return: %w", err)
+	}
+
+	res, err := strconv.ParseFloat(strings.TrimSpace(string(b)), 64)
+	if err != nil {
+		return 0, fmt.Errorf("cannot load best return: %w", err)
+	}
+	return res, nil
+}
+
+func summarizeEval(results []EpisodeResult) EvalResult {
+	res := EvalResult{Episodes: len(results)}
+	if len(results) == 0 {
+		return res
+	}
+
+	n := float64(len(results))
+	res.MinSteps = results[0].Steps
+	res.MaxSteps = results[0].Steps
+
+	finished, steps := 0, 0
+	for _, r := range results {
+		res.MeanReturn += r.Returns
+		steps += r.Steps
+		if r.Finished {
+			finished++
+		}
+		if r.Steps < res.MinSteps {
+			res.MinSteps = r.Steps
+		}
+		if r.Steps > res.MaxSteps {
+			res.MaxSteps = r.Steps
+		}
+	}
+	res.MeanReturn /= n
+	res.MeanSteps = float64(steps) / n
+	res.SuccessRate = float64(finished) / n
+
+	for _, r := range results {
+		res.StdReturn += math.Pow(r.Returns-res.MeanReturn, 2.) / n
+	}
+	res.StdReturn = math.Sqrt(res.StdReturn)
+
+	return res
+}
+
+func (rl *RL) RunEpisodeUp(ctx context.Context, episode int) (res EpisodeResult, err error) {
+	lg.Info("up start episode %d", episode)
+	res, err = rl.RunEpisode(ctx, episode, RLRunUp, true)
+	rl.stats.add(RLRunUp, res)
+	rlMetricsUp.meanReturn.Set(rl.stats.ReturnsUp / float64(rl.stats.EpisodesUp))
+	lg.With("agent", "up", "episode", episode, "returns", res.Returns, "steps", res.Steps).Info("up end episode")
 	return
 }
 
-func (rl *RL) RunEpisodeDown(ctx context.Context, episode int) (returns float64, err error) {
-	log.Printf("down start episode %d", episode)
-	returns, err = rl.RunEpisode(ctx, episode, RLRunDown)
-	log.Printf("down end episode %d returns %v", episode, returns)
+func (rl *RL) RunEpisodeDown(ctx context.Context, episode int) (res EpisodeResult, err error) {
+	lg.Info("down start episode %d", episode)
+	res, err = rl.RunEpisode(ctx, episode, RLRunDown, true)
+	rl.stats.add(RLRunDown, res)
+	rlMetricsDown.meanReturn.Set(rl.stats.ReturnsDown / float64(rl.stats.EpisodesDown))
+	lg.With("agent", "down", "episode", episode, "returns", res.Returns, "steps", res.Steps).Info("down end episode")
 	return
 }
 
-func (rl *RL) RunEpisode(ctx context.Context, episode, mode int) (returns float64, err error) {
+// RunEpisode runs an episode of mode. Unless learn is set the agent neither
+// learns nor is saved.
+func (rl *RL) RunEpisode(ctx context.Context, episode, mode int, learn bool) (res EpisodeResult, err error) {
 	defer rl.env.RunStep([]float64{0})
 
 	// Reset
@@ -254,6 +634,14 @@ func (rl *RL) RunEpisode(ctx context.Context, episode, mode int) (returns float6
 
 	ag.Reset()
 
+	start := time.Now()
+	defer func() {
+		res.Termination = termination(res, err)
+		if terr := rl.recordEpisode(ag, mode, episode, !learn, start, res); terr != nil && err == nil {
+			err = fmt.Errorf("rl run error: %w", terr)
+		}
+	}()
+
 	var s1, s2, a1, a2 []float64
 	s1, err = rl.env.State()
 	if err != nil {
@@ -262,59 +650,150 @@ func (rl *RL) RunEpisode(ctx context.Context, episode, mode int) (returns float6
 	r := rewardFunc(s1)
 	a1 = ag.Action(s1)
 
-	returns += r
+	res.Returns += r
+
+	// Record
+	var rec *recording.Episode
+	if learn && rl.recordDir != "" {
+		rec = recording.New(rl.envName, modeName(mode), episode)
+		rec.InitialState = s1
+		rec.InitialReward = r
+		rec.InitialRaw = rawFrame(rl.env)
+
+		defer func() {
+			dst := recording.Path(rl.recordDir, modeName(mode), episode)
+			if rerr := recording.Save(dst, rec); rerr != nil && err == nil {
+				err = fmt.Errorf("rl run error: %w", rerr)
+			}
+		}()
+	}
 
 	// Run
-	// logger.Get().Info("rl start episode %d", episode)
+	lg.Debug("rl start episode %d", episode)
 
 	var isFinish bool
 	var rxError *environment.RRPSerialRxError
+	var checksumError *environment.RRPChecksumError
+	var overrunError *environment.RRPOverrunError
+
+	m := modeMetrics(mode)
+	loopStart := time.Now()
 
 	for step := 0; step == -1 || step < maxStep; step++ {
 		select {
 		case <-ctx.Done():
-			return returns, nil
+			res.Termination = telemetry.TerminationInterrupted
+			return res, nil
 		default:
 		}
 
+		now := time.Now()
+		m.loop.Observe(now.Sub(loopStart).Seconds())
+		loopStart = now
+
 		if err = rl.env.RunStep(a1); err != nil {
-			if errors.As(err, &rxError) {
+			if errors.As(err, &rxError) || errors.As(err, &checksumError) {
 				continue
 			}
-			return
+			if !errors.As(err, &overrunError) {
+				return
+			}
+
+			// The transition took longer than a control period, so it is
+			// not learned. Start over from the current state instead.
+			lg.With("episode", episode, "step", res.Steps).Warn("rl %v", err)
+			if s1, err = rl.env.State(); err != nil {
+				return
+			}
+			res.Steps++
+
+			isFinish = isFinish || isFinishFunc(s1)
+			res.Finished = isFinish
+
+			if rec != nil {
+				rec.Steps = append(rec.Steps, recording.Step{
+					Action:  a1,
+					State:   s1,
+					Reward:  rewardFunc(s1),
+					Finish:  isFinish,
+					Restart: true,
+					Raw:     rawFrame(rl.env),
+				})
+			}
+
+			a1 = ag.Action(s1)
+			continue
 		}
 
 		s2, err = rl.env.State()
 		if err != nil {
 			return
 		}
+		res.Steps++
+		observeSince(m.envStep, now)
 
 		r = rewardFunc(s2)
+		finish := isFinishFunc(s2)
+
+		stepRec := &telemetry.StepRecord{
+			Time:    time.Now(),
+			Agent:   modeName(mode),
+			Eval:    !learn,
+			Episode: episode,
+			Step:    res.Steps,
+			State:   s2,
+			Action:  a1,
+			Reward:  r,
+			Finish:  finish,
+		}
+		if err = rl.telemetry.Step(stepRec); err != nil {
+			err = fmt.Errorf("rl run error: %w", err)
+			return
+		}
+		for _, o := range rl.observers {
+			o.OnStep(stepRec)
+		}
+		if rec != nil {
+			rec.Steps = append(rec.Steps, recording.Step{
+				Action: a1,
+				State:  s2,
+				Reward: r,
+				Finish: finish,
+				Raw:    rawFrame(rl.env),
+			})
+		}
 
+		agentStart := time.Now()
 		a2 = ag.Action(s2)
 
-		ag.Learn(s1, a1, r, s2, a2)
+		if learn {
+			ag.Learn(s1, a1, r, s2, a2)
+		}
+		observeSince(m.agentStep, agentStart)
 
 		if isFinish {
 			break
 		}
 
-		isFinish = isFinishFunc(s2)
+		isFinish = finish
+		res.Finished = isFinish
 
 		s1 = s2
 		a1 = a2
-		returns += r
+		res.Returns += r
 	}
 
-	if err = rl.env.RunStep([]float64{0}); err != nil {
-		if errors.As(err, &rxError) {
+	if err = rl.env.RunStep([]float64{0}); errors.As(err, &overrunError) {
+		err = nil
+	} else if err != nil {
+		if errors.As(err, &rxError) || errors.As(err, &checksumError) {
 			return
 		}
 		return
 	}
 
 	// Save
-	if rl.agentSaveFreq == -1 || episode%rl.agentSaveFreq == 0 {
+	if learn && rl.isSaveEpisode(episode) {
 		if err = ag.Save(agentDataPath); err != nil {
 			err = fmt.Errorf("rl run error: %w", err)
 			return
@@ -324,6 +803,99 @@ func (rl *RL) RunEpisode(ctx context.Context, episode, mode int) (returns float6
 	return
 }
 
+// rawFrame returns the raw frame of the current state of env if it has one.
+func rawFrame(env environment.Environment) []byte {
+	if rf, ok := env.(environment.RawFramer); ok {
+		return rf.LastFrame()
+	}
+	return nil
+}
+
+func (rl *RL) isSaveEpisode(episode int) bool {
+	return rl.agentSaveFreq == -1 || episode%rl.agentSaveFreq == 0
+}
+
+// termination returns the termination reason of an episode which ended with
+// res and err.
+func termination(res EpisodeResult, err error) string {
+	switch {
+	case res.Termination != "":
+		return res.Termination
+	case err != nil && !errors.Is(EndOfEpisode, err):
+		return telemetry.TerminationError
+	case res.Finished:
+		return telemetry.TerminationFinish
+	default:
+		return telemetry.TerminationMaxStep
+	}
+}
+
+// recordEpisode writes the telemetry and updates the metrics of an episode.
+func (rl *RL) recordEpisode(ag agent.Agent, mode, episode int, eval bool, start time.Time, res EpisodeResult) error {
+	now := time.Now()
+
+	rec := &telemetry.EpisodeRecord{
+		Time:        now,
+		Agent:       modeName(mode),
+		Eval:        eval,
+		Episode:     episode,
+		Returns:     res.Returns,
+		Steps:       res.Steps,
+		Termination: res.Termination,
+		Seconds:     now.Sub(start).Seconds(),
+	}
+	if er, ok := ag.(agent.EpsilonReporter); ok {
+		if eps, ok := er.Epsilon(); ok {
+			rec.Epsilon = &eps
+		}
+	}
+	if ec, ok := ag.(agent.EpisodeCounter); ok {
+		n := ec.EpisodeCount()
+		rec.AgentEpisodes = &n
+	}
+
+	m := modeMetrics(mode)
+	if eval {
+		m.evalEpisodes.Inc()
+	} else {
+		m.episodes.Inc()
+		m.lastReturn.Set(res.Returns)
+		m.lastSteps.Set(float64(res.Steps))
+		if rec.Epsilon != nil {
+			m.epsilon.Set(*rec.Epsilon)
+		}
+	}
+	if rec.AgentEpisodes != nil {
+		m.agentEpisodes.Set(float64(*rec.AgentEpisodes))
+	}
+
+	for _, o := range rl.observers {
+		o.OnEpisode(rec)
+	}
+
+	return rl.telemetry.Episode(rec)
+}
+
+func modeName(mode int) string {
+	switch mode {
+	case RLRunUp:
+		return "up"
+	case RLRunDown:
+		return "down"
+	default:
+		return strconv.Itoa(mode)
+	}
+}
+
+// AddObserver makes o receive the steps and episodes run after.
+func (rl *RL) AddObserver(o Observer) {
+	rl.observers = append(rl.observers, o)
+}
+
 func (rl *RL) Close() error {
+	if err := rl.telemetry.Close(); err != nil {
+		rl.env.Close()
+		return err
+	}
 	return rl.env.Close()
 }
@@ -0,0 +1,247 @@
+package lab_scup2020
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/high-moctane/lab_scup2020/telemetry"
+	"github.com/high-moctane/lab_scup2020/utils"
+)
+
+func setTestEnv(t *testing.T, env map[string]string) {
+	t.Helper()
+
+	for k, v := range env {
+		prev, ok := os.LookupEnv(k)
+		if err := os.Setenv(k, v); err != nil {
+			t.Fatal(err)
+		}
+		k := k
+		t.Cleanup(func() {
+			if ok {
+				os.Setenv(k, prev)
+			} else {
+				os.Unsetenv(k)
+			}
+		})
+	}
+}
+
+// testRLEnv is a small Cartpole config whose files are in dir.
+func testRLEnv(dir string) map[string]string {
+	return map[string]string{
+		"SCUP_LOG_LEVEL":               "WARN",
+		"SCUP_SEED":                    "1",
+		"SCUP_RL_AGENT_UP_DATA_PATH":   filepath.Join(dir, "agent_up.gob"),
+		"SCUP_RL_AGENT_DOWN_DATA_PATH": filepath.Join(dir, "agent_down.gob"),
+		"SCUP_RL_AGENT_SAVE_FREQUENT":  "1",
+		"SCUP_RL_MAX_EPISODE":          "3",
+		"SCUP_RL_MAX_STEP_UP":          "50",
+		"SCUP_RL_MAX_STEP_DOWN":        "50",
+		"SCUP_ENV_NAME":                "Cartpole",
+		"SCUP_ENV_RESET_NOISE":         "0.05",
+		"SCUP_AGENT_NAME":              "Q-Learning",
+		"SCUP_AGENT_INIT_QVALUE":       "0",
+		"SCUP_AGENT_STATE_THRESH":      "-1.57,1.57:-3.14,3.14:-3,3:-10,10",
+		"SCUP_AGENT_STATE_NUMBER":      "4:8:4:8",
+		"SCUP_AGENT_ACTION":            "-1:0:1",
+		"SCUP_AGENT_ALPHA":             "0.1",
+		"SCUP_AGENT_GAMMA":             "0.99",
+		"SCUP_AGENT_EPSILON":           "0.1",
+	}
+}
+
+func newTestRL(t *testing.T) *RL {
+	t.Helper()
+
+	rl, err := NewRL()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { rl.Close() })
+	return rl
+}
+
+func runTestEpisodes(t *testing.T, rl *RL, n int) []EpisodeResult {
+	t.Helper()
+
+	res := []EpisodeResult{}
+	for episode := rl.startEpisode; episode < rl.startEpisode+n; episode++ {
+		r, err := rl.RunEpisodeUp(context.Background(), episode)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res = append(res, r)
+	}
+	return res
+}
+
+func TestSeedDeterminism(t *testing.T) {
+	results := [][]EpisodeResult{}
+	for i := 0; i < 2; i++ {
+		setTestEnv(t, testRLEnv(t.TempDir()))
+		results = append(results, runTestEpisodes(t, newTestRL(t), 3))
+	}
+
+	if !reflect.DeepEqual(results[0], results[1]) {
+		t.Errorf("expected the same episodes for the same seed, but %v and %v", results[0], results[1])
+	}
+}
+
+func TestRunStateSaveLoad(t *testing.T) {
+	env := testRLEnv(t.TempDir())
+	env["SCUP_RL_RUN_STATE_PATH"] = filepath.Join(t.TempDir(), "run_state.gob")
+	setTestEnv(t, env)
+
+	rl := newTestRL(t)
+	runTestEpisodes(t, rl, 3)
+	if err := rl.saveRunState(2); err != nil {
+		t.Fatal(err)
+	}
+
+	resumed := newTestRL(t)
+
+	if resumed.startEpisode != 3 {
+		t.Errorf("expected to resume from episode 3, but %d", resumed.startEpisode)
+	}
+	if resumed.seed != rl.seed {
+		t.Errorf("expected seed %d, but %d", rl.seed, resumed.seed)
+	}
+	if resumed.stats != rl.stats {
+		t.Errorf("expected stats %+v, but %+v", rl.stats, resumed.stats)
+	}
+
+	expected := rl.randomized()
+	for name, v := range resumed.randomized() {
+		r, ok := v.(utils.Randomized)
+		if !ok {
+			t.Fatalf("%s has no random source", name)
+		}
+		if s := expected[name].(utils.Randomized).RandState(); r.RandState() != s {
+			t.Errorf("expected %s random state %d, but %d", name, s, r.RandState())
+		}
+	}
+
+	// The resumed run continues the random sequence of the original one.
+	if !reflect.DeepEqual(runTestEpisodes(t, resumed, 2), runTestEpisodes(t, rl, 2)) {
+		t.Error("expected the resumed episodes to match the original run")
+	}
+}
+
+func TestEvalPeriodicallyBest(t *testing.T) {
+	dir := t.TempDir()
+	env := testRLEnv(dir)
+	env["SCUP_RL_EVAL_FREQUENT"] = "1"
+	env["SCUP_RL_EVAL_EPISODE"] = "1"
+	env["SCUP_AGENT_SAVE_KEEP"] = "2"
+	setTestEnv(t, env)
+
+	rl := newTestRL(t)
+	if err := rl.evalPeriodically(context.Background(), 0, RLRunUp); err != nil {
+		t.Fatal(err)
+	}
+
+	best := BestDataPath(env["SCUP_RL_AGENT_UP_DATA_PATH"])
+	if _, err := os.Stat(best); err != nil {
+		t.Fatal(err)
+	}
+	saved, err := loadBestReturn(best)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved != rl.stats.BestReturnUp {
+		t.Errorf("expected saved return %v, but %v", rl.stats.BestReturnUp, saved)
+	}
+
+	// The best agent is not numbered.
+	if paths, _ := filepath.Glob(filepath.Join(dir, "agent_up.best.ep*")); len(paths) != 0 {
+		t.Errorf("expected no numbered best agents, but %v", paths)
+	}
+
+	// A new run without a run state does not replace a better agent.
+	if err := utils.WriteFileAtomic(bestReturnPath(best), []byte("1e9\n")); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(best)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rl = newTestRL(t)
+	if err := rl.evalPeriodically(context.Background(), 0, RLRunUp); err != nil {
+		t.Fatal(err)
+	}
+	if after, err := os.Stat(best); err != nil || !after.ModTime().Equal(info.ModTime()) {
+		t.Errorf("expected %s to be kept, but %v", best, err)
+	}
+	if rl.stats.BestReturnUp != 1e9 {
+		t.Errorf("expected best return 1e9, but %v", rl.stats.BestReturnUp)
+	}
+}
+
+func TestNewRLActionRange(t *testing.T) {
+	env := testRLEnv(t.TempDir())
+	env["SCUP_AGENT_ACTION"] = "-2:0:2"
+	setTestEnv(t, env)
+
+	// Cartpole takes actions in [-1, 1].
+	if rl, err := NewRL(); err == nil {
+		rl.Close()
+		t.Error("expected an error for actions out of range")
+	}
+}
+
+// cancelObserver cancels an evaluation after a few steps.
+type cancelObserver struct {
+	cancel context.CancelFunc
+}
+
+func (co *cancelObserver) OnStep(rec *telemetry.StepRecord) {
+	if rec.Eval && rec.Step == 2 {
+		co.cancel()
+	}
+}
+
+func (co *cancelObserver) OnEpisode(rec *telemetry.EpisodeRecord) {}
+
+func TestEvalPeriodicallyInterrupted(t *testing.T) {
+	dir := t.TempDir()
+	env := testRLEnv(dir)
+	env["SCUP_RL_EVAL_FREQUENT"] = "1"
+	env["SCUP_RL_EVAL_EPISODE"] = "1"
+	setTestEnv(t, env)
+
+	rl := newTestRL(t)
+	if err := rl.evalPeriodically(context.Background(), 0, RLRunUp); err != nil {
+		t.Fatal(err)
+	}
+
+	// Any return of a whole episode is better than this.
+	best := BestDataPath(env["SCUP_RL_AGENT_UP_DATA_PATH"])
+	if err := utils.WriteFileAtomic(bestReturnPath(best), []byte("-1e18\n")); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(best)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rl = newTestRL(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	rl.AddObserver(&cancelObserver{cancel})
+
+	if err := rl.evalPeriodically(ctx, 0, RLRunUp); err != nil {
+		t.Fatal(err)
+	}
+
+	if after, err := os.Stat(best); err != nil || !after.ModTime().Equal(info.ModTime()) {
+		t.Errorf("expected %s to be kept, but %v", best, err)
+	}
+	if saved, err := loadBestReturn(best); err != nil || saved != -1e18 {
+		t.Errorf("expected saved return -1e18, but %v (%v)", saved, err)
+	}
+}
@@ -0,0 +1,150 @@
+package lab_scup2020
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+
+	"github.com/high-moctane/lab_scup2020/utils"
+)
+
+const runStateVersion = 1
+
+// RunState is what RL needs besides the agent data to continue a killed run
+// from its last save episode: the episode counter, the seed, the random
+// source states and the cumulative statistics. It is saved together with the
+// agents after the periodic evaluation of a save episode, so it matches the
+// last agent checkpoint and the episodes since then run again.
+//
+// A resumed run is not the same as an uninterrupted one. Agent state outside
+// the checkpoints is not restored, e.g. the DQN and DDPG replay buffers, the
+// UCB counts and the OU noise. Exploration schedules keyed on episodes
+// continue from the episode count in the agent checkpoint.
+type RunState struct {
+	Version int
+
+	// Episode is the next episode to run.
+	Episode int
+
+	// Seed is the SCUP_SEED the run started with.
+	Seed int64
+
+	// RandStates holds the random source states of the agents and the
+	// environment by "up", "down" and "env".
+	RandStates map[string]uint64
+
+	Stats RunStats
+}
+
+// RunStats is cumulative statistics over a run including resumed parts.
+type RunStats struct {
+	EpisodesUp, EpisodesDown     int
+	StepsUp, StepsDown           int
+	ReturnsUp, ReturnsDown       float64
+	BestReturnUp, BestReturnDown float64
+}
+
+func newRunStats() RunStats {
+	return RunStats{
+		BestReturnUp:   math.Inf(-1),
+		BestReturnDown: math.Inf(-1),
+	}
+}
+
+func (rs *RunStats) add(mode int, res EpisodeResult) {
+	switch mode {
+	case RLRunUp:
+		rs.EpisodesUp++
+		rs.StepsUp += res.Steps
+		rs.ReturnsUp += res.Returns
+	case RLRunDown:
+		rs.EpisodesDown++
+		rs.StepsDown += res.Steps
+		rs.ReturnsDown += res.Returns
+	}
+}
+
+// saveRunState saves the run state after episode if the agents were saved in
+// it.
+func (rl *RL) saveRunState(episode int) error {
+	if rl.runStatePath == "" || !rl.isSaveEpisode(episode) {
+		return nil
+	}
+
+	state := RunState{
+		Version:    runStateVersion,
+		Episode:    episode + 1,
+		Seed:       rl.seed,
+		RandStates: map[string]uint64{},
+		Stats:      rl.stats,
+	}
+	for name, v := range rl.randomized() {
+		if r, ok := v.(utils.Randomized); ok {
+			state.RandStates[name] = r.RandState()
+		}
+	}
+
+	buf := bytes.NewBuffer(nil)
+	if err := gob.NewEncoder(buf).Encode(&state); err != nil {
+		return fmt.Errorf("cannot save run state to %s: %w", rl.runStatePath, err)
+	}
+
+	if err := utils.WriteFileAtomic(rl.runStatePath, buf.Bytes()); err != nil {
+		return fmt.Errorf("cannot save run state to %s: %w", rl.runStatePath, err)
+	}
+
+	return nil
+}
+
+// loadRunState restores the run state. A missing file starts a new run.
+func (rl *RL) loadRunState() error {
+	pathError := new(os.PathError)
+
+	f, err := os.Open(rl.runStatePath)
+	if err != nil {
+		if errors.As(err, &pathError) {
+			return nil
+		}
+		return fmt.Errorf("cannot load run state from %s: %w", rl.runStatePath, err)
+	}
+	defer f.Close()
+
+	var state RunState
+	if err := gob.NewDecoder(bufio.NewReader(f)).Decode(&state); err != nil {
+		return fmt.Errorf("cannot load run state from %s: %w", rl.runStatePath, err)
+	}
+	if state.Version != runStateVersion {
+		return fmt.Errorf("cannot load run state from %s: version %d", rl.runStatePath, state.Version)
+	}
+
+	for name, v := range rl.randomized() {
+		s, ok := state.RandStates[name]
+		if !ok {
+			continue
+		}
+		if r, ok := v.(utils.Randomized); ok {
+			r.SetRandState(s)
+		}
+	}
+
+	rl.seed = state.Seed
+	rl.startEpisode = state.Episode
+	rl.stats = state.Stats
+
+	lg.Info("resume from episode %d with seed %d (up %d episodes, down %d episodes)",
+		state.Episode, state.Seed, state.Stats.EpisodesUp, state.Stats.EpisodesDown)
+
+	return nil
+}
+
+func (rl *RL) randomized() map[string]interface{} {
+	return map[string]interface{}{
+		"up":   rl.agentUp,
+		"down": rl.agentDown,
+		"env":  rl.env,
+	}
+}
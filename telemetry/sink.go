@@ -0,0 +1,108 @@
+package telemetry
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// Formats of telemetry files
+const (
+	FormatCSV   = "csv"
+	FormatJSONL = "jsonl"
+)
+
+type record interface {
+	csvHeader() []string
+	csvRow() []string
+}
+
+type sink interface {
+	write(rec record) error
+	flush() error
+	Close() error
+}
+
+func openSink(path, format string) (sink, error) {
+	switch format {
+	case FormatCSV, FormatJSONL:
+	default:
+		return nil, fmt.Errorf("invalid format: %s", format)
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	w := bufio.NewWriter(f)
+
+	if format == FormatJSONL {
+		return &jsonlSink{f, w, json.NewEncoder(w)}, nil
+	}
+	// A header is written only to a new file.
+	return &csvSink{f, w, csv.NewWriter(w), info.Size() > 0}, nil
+}
+
+type csvSink struct {
+	f      *os.File
+	w      *bufio.Writer
+	cw     *csv.Writer
+	header bool
+}
+
+func (s *csvSink) write(rec record) error {
+	if !s.header {
+		if err := s.cw.Write(rec.csvHeader()); err != nil {
+			return err
+		}
+		s.header = true
+	}
+	return s.cw.Write(rec.csvRow())
+}
+
+func (s *csvSink) flush() error {
+	s.cw.Flush()
+	if err := s.cw.Error(); err != nil {
+		return err
+	}
+	return s.w.Flush()
+}
+
+func (s *csvSink) Close() error {
+	if err := s.flush(); err != nil {
+		s.f.Close()
+		return err
+	}
+	return s.f.Close()
+}
+
+type jsonlSink struct {
+	f   *os.File
+	w   *bufio.Writer
+	enc *json.Encoder
+}
+
+func (s *jsonlSink) write(rec record) error {
+	return s.enc.Encode(rec)
+}
+
+func (s *jsonlSink) flush() error {
+	return s.w.Flush()
+}
+
+func (s *jsonlSink) Close() error {
+	if err := s.flush(); err != nil {
+		s.f.Close()
+		return err
+	}
+	return s.f.Close()
+}
@@ -0,0 +1,209 @@
+package telemetry
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/high-moctane/lab_scup2020/utils"
+)
+
+// Termination reasons of an episode
+const (
+	TerminationFinish      = "finish"
+	TerminationMaxStep     = "max_step"
+	TerminationInterrupted = "interrupted"
+	TerminationError       = "error"
+)
+
+// StepRecord is a step of an episode. State is the state after Action is
+// taken and Reward is the reward of State.
+type StepRecord struct {
+	Time    time.Time `json:"time"`
+	Agent   string    `json:"agent"`
+	Eval    bool      `json:"eval"`
+	Episode int       `json:"episode"`
+	Step    int       `json:"step"`
+	State   []float64 `json:"state"`
+	Action  []float64 `json:"action"`
+	Reward  float64   `json:"reward"`
+	Finish  bool      `json:"finish"`
+}
+
+// EpisodeRecord is the outcome of an episode. Epsilon and AgentEpisodes are
+// nil if the agent does not report them.
+type EpisodeRecord struct {
+	Time          time.Time `json:"time"`
+	Agent         string    `json:"agent"`
+	Eval          bool      `json:"eval"`
+	Episode       int       `json:"episode"`
+	Returns       float64   `json:"returns"`
+	Steps         int       `json:"steps"`
+	Termination   string    `json:"termination"`
+	Seconds       float64   `json:"seconds"`
+	Epsilon       *float64  `json:"epsilon,omitempty"`
+	AgentEpisodes *int      `json:"agent_episodes,omitempty"`
+}
+
+// Recorder writes step and episode records to files. Steps are recorded
+// every SCUP_TELEMETRY_STEP_SAMPLE steps of every
+// SCUP_TELEMETRY_EPISODE_SAMPLE episodes. A Recorder without paths records
+// nothing.
+type Recorder struct {
+	steps, episodes sink
+
+	stepSample, episodeSample int
+}
+
+// New makes a Recorder from the env:
+//
+//	SCUP_TELEMETRY_STEP_PATH       step records file (optional)
+//	SCUP_TELEMETRY_EPISODE_PATH    episode records file (optional)
+//	SCUP_TELEMETRY_FORMAT          csv (default) or jsonl
+//	SCUP_TELEMETRY_STEP_SAMPLE     record every n-th step (default 1)
+//	SCUP_TELEMETRY_EPISODE_SAMPLE  record steps of every n-th episode (default 1)
+//
+// Files are appended to so that a resumed run continues them.
+func New() (*Recorder, error) {
+	format := utils.GetEnvStringDefault("SCUP_TELEMETRY_FORMAT", FormatCSV)
+	stepPath := utils.GetEnvStringDefault("SCUP_TELEMETRY_STEP_PATH", "")
+	episodePath := utils.GetEnvStringDefault("SCUP_TELEMETRY_EPISODE_PATH", "")
+
+	stepSample, err := utils.GetEnvIntDefault("SCUP_TELEMETRY_STEP_SAMPLE", 1)
+	if err != nil {
+		return nil, fmt.Errorf("cannot make telemetry: %w", err)
+	}
+	episodeSample, err := utils.GetEnvIntDefault("SCUP_TELEMETRY_EPISODE_SAMPLE", 1)
+	if err != nil {
+		return nil, fmt.Errorf("cannot make telemetry: %w", err)
+	}
+	if stepSample < 1 || episodeSample < 1 {
+		return nil, fmt.Errorf("cannot make telemetry: sample must be positive")
+	}
+
+	res := &Recorder{stepSample: stepSample, episodeSample: episodeSample}
+
+	if stepPath != "" {
+		res.steps, err = openSink(stepPath, format)
+		if err != nil {
+			return nil, fmt.Errorf("cannot make telemetry: %w", err)
+		}
+	}
+
+	if episodePath != "" {
+		res.episodes, err = openSink(episodePath, format)
+		if err != nil {
+			if res.steps != nil {
+				res.steps.Close()
+			}
+			return nil, fmt.Errorf("cannot make telemetry: %w", err)
+		}
+	}
+
+	return res, nil
+}
+
+// Step records rec if it is sampled.
+func (r *Recorder) Step(rec *StepRecord) error {
+	if r.steps == nil || rec.Episode%r.episodeSample != 0 || rec.Step%r.stepSample != 0 {
+		return nil
+	}
+	if err := r.steps.write(rec); err != nil {
+		return fmt.Errorf("cannot record step: %w", err)
+	}
+	return nil
+}
+
+// Episode records rec and flushes the step records of the episode.
+func (r *Recorder) Episode(rec *EpisodeRecord) error {
+	if r.steps != nil {
+		if err := r.steps.flush(); err != nil {
+			return fmt.Errorf("cannot record episode: %w", err)
+		}
+	}
+	if r.episodes == nil {
+		return nil
+	}
+	if err := r.episodes.write(rec); err != nil {
+		return fmt.Errorf("cannot record episode: %w", err)
+	}
+	if err := r.episodes.flush(); err != nil {
+		return fmt.Errorf("cannot record episode: %w", err)
+	}
+	return nil
+}
+
+func (r *Recorder) Close() error {
+	var res error
+	for _, s := range []sink{r.steps, r.episodes} {
+		if s == nil {
+			continue
+		}
+		if err := s.Close(); err != nil && res == nil {
+			res = fmt.Errorf("cannot close telemetry: %w", err)
+		}
+	}
+	return res
+}
+
+func (rec *StepRecord) csvHeader() []string {
+	res := []string{"time", "agent", "eval", "episode", "step"}
+	for i := range rec.State {
+		res = append(res, "state"+strconv.Itoa(i))
+	}
+	for i := range rec.Action {
+		res = append(res, "action"+strconv.Itoa(i))
+	}
+	return append(res, "reward", "finish")
+}
+
+func (rec *StepRecord) csvRow() []string {
+	res := []string{
+		rec.Time.Format(time.RFC3339Nano),
+		rec.Agent,
+		strconv.FormatBool(rec.Eval),
+		strconv.Itoa(rec.Episode),
+		strconv.Itoa(rec.Step),
+	}
+	for _, v := range rec.State {
+		res = append(res, formatFloat(v))
+	}
+	for _, v := range rec.Action {
+		res = append(res, formatFloat(v))
+	}
+	return append(res, formatFloat(rec.Reward), strconv.FormatBool(rec.Finish))
+}
+
+func (rec *EpisodeRecord) csvHeader() []string {
+	return []string{
+		"time", "agent", "eval", "episode", "returns", "steps",
+		"termination", "seconds", "epsilon", "agent_episodes",
+	}
+}
+
+func (rec *EpisodeRecord) csvRow() []string {
+	eps, agentEpisodes := "", ""
+	if rec.Epsilon != nil {
+		eps = formatFloat(*rec.Epsilon)
+	}
+	if rec.AgentEpisodes != nil {
+		agentEpisodes = strconv.Itoa(*rec.AgentEpisodes)
+	}
+
+	return []string{
+		rec.Time.Format(time.RFC3339Nano),
+		rec.Agent,
+		strconv.FormatBool(rec.Eval),
+		strconv.Itoa(rec.Episode),
+		formatFloat(rec.Returns),
+		strconv.Itoa(rec.Steps),
+		rec.Termination,
+		formatFloat(rec.Seconds),
+		eps,
+		agentEpisodes,
+	}
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,149 @@
+package telemetry
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func setTestEnv(t *testing.T, env map[string]string) {
+	t.Helper()
+
+	for k, v := range env {
+		prev, ok := os.LookupEnv(k)
+		if err := os.Setenv(k, v); err != nil {
+			t.Fatal(err)
+		}
+		k := k
+		t.Cleanup(func() {
+			if ok {
+				os.Setenv(k, prev)
+			} else {
+				os.Unsetenv(k)
+			}
+		})
+	}
+}
+
+func recordTestEpisodes(t *testing.T, episodes, steps int) {
+	t.Helper()
+
+	rec, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	eps := 0.1
+	for episode := 0; episode < episodes; episode++ {
+		for step := 0; step < steps; step++ {
+			if err := rec.Step(&StepRecord{
+				Time:    time.Now(),
+				Agent:   "up",
+				Episode: episode,
+				Step:    step,
+				State:   []float64{float64(step), 0.5},
+				Action:  []float64{1},
+				Reward:  -1,
+			}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := rec.Episode(&EpisodeRecord{
+			Time:        time.Now(),
+			Agent:       "up",
+			Episode:     episode,
+			Returns:     float64(-steps),
+			Steps:       steps,
+			Termination: TerminationMaxStep,
+			Epsilon:     &eps,
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := rec.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readLines(t *testing.T, path string) []string {
+	t.Helper()
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Split(strings.TrimSpace(string(b)), "\n")
+}
+
+func TestRecorderCSV(t *testing.T) {
+	dir := t.TempDir()
+	stepPath := filepath.Join(dir, "steps.csv")
+	episodePath := filepath.Join(dir, "episodes.csv")
+
+	setTestEnv(t, map[string]string{
+		"SCUP_TELEMETRY_STEP_PATH":      stepPath,
+		"SCUP_TELEMETRY_EPISODE_PATH":   episodePath,
+		"SCUP_TELEMETRY_STEP_SAMPLE":    "2",
+		"SCUP_TELEMETRY_EPISODE_SAMPLE": "2",
+	})
+
+	// A resumed run appends without a second header.
+	recordTestEpisodes(t, 4, 5)
+	recordTestEpisodes(t, 4, 5)
+
+	steps := readLines(t, stepPath)
+	if expected := "time,agent,eval,episode,step,state0,state1,action0,reward,finish"; steps[0] != expected {
+		t.Errorf("expected header %q, but %q", expected, steps[0])
+	}
+	// Episodes 0 and 2, steps 0, 2 and 4, twice
+	if len(steps) != 1+2*2*3 {
+		t.Errorf("expected %d step lines, but %d", 1+2*2*3, len(steps))
+	}
+
+	episodes := readLines(t, episodePath)
+	if len(episodes) != 1+2*4 {
+		t.Errorf("expected %d episode lines, but %d", 1+2*4, len(episodes))
+	}
+	if !strings.HasSuffix(episodes[1], ",max_step,0,0.1,") {
+		t.Errorf("unexpected episode line %q", episodes[1])
+	}
+}
+
+func TestRecorderJSONL(t *testing.T) {
+	episodePath := filepath.Join(t.TempDir(), "episodes.jsonl")
+
+	setTestEnv(t, map[string]string{
+		"SCUP_TELEMETRY_FORMAT":       FormatJSONL,
+		"SCUP_TELEMETRY_EPISODE_PATH": episodePath,
+	})
+
+	recordTestEpisodes(t, 3, 2)
+
+	f, err := os.Open(episodePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	n := 0
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		var rec EpisodeRecord
+		if err := json.Unmarshal(sc.Bytes(), &rec); err != nil {
+			t.Fatal(err)
+		}
+		if rec.Episode != n || rec.Steps != 2 || rec.Epsilon == nil || *rec.Epsilon != 0.1 || rec.AgentEpisodes != nil {
+			t.Errorf("unexpected record %s", sc.Text())
+		}
+		n++
+	}
+	if n != 3 {
+		t.Errorf("expected 3 records, but %d", n)
+	}
+}
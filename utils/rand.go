@@ -0,0 +1,91 @@
+package utils
+
+import (
+	"math/rand"
+	"time"
+)
+
+// Source is a splitmix64 random source. Unlike the sources of math/rand its
+// whole state is a single uint64, so it can be saved and restored.
+type Source struct {
+	state uint64
+}
+
+func NewSource(seed int64) *Source {
+	return &Source{uint64(seed)}
+}
+
+func (s *Source) Seed(seed int64) {
+	s.state = uint64(seed)
+}
+
+func (s *Source) Uint64() uint64 {
+	s.state += 0x9e3779b97f4a7c15
+	z := s.state
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return z ^ (z >> 31)
+}
+
+func (s *Source) Int63() int64 {
+	return int64(s.Uint64() >> 1)
+}
+
+func (s *Source) State() uint64 {
+	return s.state
+}
+
+func (s *Source) SetState(state uint64) {
+	s.state = state
+}
+
+// Randomized is implemented by agents and environments which own a Source.
+// Seed must be called before Init to take effect on initialization.
+type Randomized interface {
+	Seed(seed int64)
+	RandState() uint64
+	SetRandState(state uint64)
+}
+
+// Randomness is a random number generator on a Source. Agents and
+// environments embed it to implement Randomized.
+type Randomness struct {
+	src *Source
+	rng *rand.Rand
+}
+
+// InitRand makes the generator unless it is already seeded.
+func (r *Randomness) InitRand() {
+	if r.src != nil {
+		return
+	}
+	r.Seed(time.Now().UnixNano())
+}
+
+// Rand returns the generator. InitRand or Seed must be called first.
+func (r *Randomness) Rand() *rand.Rand {
+	return r.rng
+}
+
+func (r *Randomness) Seed(seed int64) {
+	r.src = NewSource(seed)
+	r.rng = rand.New(r.src)
+}
+
+func (r *Randomness) RandState() uint64 {
+	return r.src.State()
+}
+
+func (r *Randomness) SetRandState(state uint64) {
+	r.src.SetState(state)
+}
+
+// SplitSeed derives n independent seeds from seed.
+func SplitSeed(seed int64, n int) []int64 {
+	src := NewSource(seed)
+	res := make([]int64, n)
+	for i := range res {
+		res[i] = src.Int63()
+	}
+	return res
+}
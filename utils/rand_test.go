@@ -0,0 +1,60 @@
+package utils
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestSourceState(t *testing.T) {
+	src := NewSource(42)
+	rng := rand.New(src)
+
+	for i := 0; i < 10; i++ {
+		rng.Float64()
+	}
+	state := src.State()
+
+	expected := []float64{rng.Float64(), rng.NormFloat64(), float64(rng.Intn(100))}
+
+	src.SetState(state)
+	got := []float64{rng.Float64(), rng.NormFloat64(), float64(rng.Intn(100))}
+
+	for i := range expected {
+		if expected[i] != got[i] {
+			t.Errorf("[%d] expected %v, but %v", i, expected[i], got[i])
+		}
+	}
+}
+
+func TestSplitSeed(t *testing.T) {
+	seeds := SplitSeed(1, 3)
+	if seeds[0] == seeds[1] || seeds[1] == seeds[2] || seeds[0] == seeds[2] {
+		t.Errorf("seeds must differ: %v", seeds)
+	}
+
+	again := SplitSeed(1, 3)
+	for i := range seeds {
+		if seeds[i] != again[i] {
+			t.Errorf("seeds must be deterministic: %v, %v", seeds, again)
+		}
+	}
+}
+
+func TestRandomness(t *testing.T) {
+	var r Randomness
+	r.Seed(7)
+	r.InitRand() // keeps the seed
+
+	var seeded Randomness
+	seeded.Seed(7)
+	if a, b := r.Rand().Int63(), seeded.Rand().Int63(); a != b {
+		t.Errorf("InitRand must keep the seed: %v, %v", a, b)
+	}
+
+	state := r.RandState()
+	expected := r.Rand().Float64()
+	r.SetRandState(state)
+	if got := r.Rand().Float64(); expected != got {
+		t.Errorf("expected %v, but %v", expected, got)
+	}
+}
@@ -33,3 +33,39 @@ func GetEnvFloat64(env string) (float64, error) {
 
 	return res, nil
 }
+
+func GetEnvInt64(env string) (int64, error) {
+	str, ok := os.LookupEnv(env)
+	if !ok {
+		return 0, fmt.Errorf("cannot get %v", env)
+	}
+
+	res, err := strconv.ParseInt(str, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid env %v: %w", env, err)
+	}
+
+	return res, nil
+}
+
+func GetEnvStringDefault(env, def string) string {
+	str, ok := os.LookupEnv(env)
+	if !ok {
+		return def
+	}
+	return str
+}
+
+func GetEnvIntDefault(env string, def int) (int, error) {
+	if _, ok := os.LookupEnv(env); !ok {
+		return def, nil
+	}
+	return GetEnvInt(env)
+}
+
+func GetEnvFloat64Default(env string, def float64) (float64, error) {
+	if _, ok := os.LookupEnv(env); !ok {
+		return def, nil
+	}
+	return GetEnvFloat64(env)
+}